@@ -0,0 +1,154 @@
+// Package auth checks the bearer tokens of API requests: JWTs signed by an
+// OpenID Connect issuer, verified with the keys of its JWKS. The keys are
+// cached and fetched again when they expire or a token names a key the cache
+// does not have, so the issuer can rotate its keys at any time.
+//
+// Any JWKS will do, so a service can be tested against a local stand-in by
+// pointing AUTH_JWKS_URL at a file server holding a JWKS of test keys. The
+// issuer and audience are always checked, so config validation requires both
+// once authentication is on.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kindergarten-core/config"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// signatureAlgorithms are the algorithms tokens may be signed with; the
+// symmetric ones and "none" are refused, whatever key a JWKS offers.
+var signatureAlgorithms = map[string]bool{
+	string(jose.RS256): true, string(jose.RS384): true, string(jose.RS512): true,
+	string(jose.PS256): true, string(jose.PS384): true, string(jose.PS512): true,
+	string(jose.ES256): true, string(jose.ES384): true, string(jose.ES512): true,
+	string(jose.EdDSA): true,
+}
+
+var (
+	// ErrNoToken is returned for requests without a bearer token.
+	ErrNoToken = errors.New("bearer token required")
+	// ErrKeysUnavailable is returned while the JWKS cannot be fetched, so no
+	// token can be checked.
+	ErrKeysUnavailable = errors.New("signing keys unavailable")
+)
+
+var (
+	// settings is nil while authentication is off
+	settings *config.Auth
+	keys     *keySet
+)
+
+// Init turns authentication on when cfg names an issuer or a JWKS. The JWKS
+// is fetched with the first request, so a service starts while the issuer is
+// unreachable.
+func Init(cfg config.Auth) {
+	if cfg.Issuer == "" && cfg.JWKSURL == "" {
+		slog.Warn("Authentication disabled; set AUTH_ISSUER or AUTH_JWKS_URL to require bearer tokens")
+		return
+	}
+	settings = &cfg
+	keys = newKeySet(cfg.Issuer, cfg.JWKSURL, cfg.JWKSCacheTTL)
+	slog.Info("Authentication enabled", "issuer", cfg.Issuer, "jwks_url", cfg.JWKSURL, "audience", cfg.Audience)
+}
+
+// Enabled reports whether requests need a bearer token.
+func Enabled() bool {
+	return settings != nil
+}
+
+// Principal is who a token was issued to.
+type Principal struct {
+	// Subject is the sub claim, the ID of the user at the issuer.
+	Subject string
+	// User is the user claim, falling back to the subject.
+	User string
+	// Tenant is the tenant claim, the kindergarten branch; empty when absent.
+	Tenant string
+	// Claims are all claims of the token.
+	Claims map[string]any
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal of an authenticated request, or nil.
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Authenticate verifies the bearer token of r and returns its principal. The
+// error is ErrNoToken, ErrKeysUnavailable or says why the token was refused;
+// it is meant for the client and never carries the token itself.
+func Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	token = strings.TrimSpace(token)
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, ErrNoToken
+	}
+	return verify(r.Context(), token)
+}
+
+func verify(ctx context.Context, raw string) (*Principal, error) {
+	token, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	if len(token.Headers) != 1 {
+		return nil, errors.New("token must have exactly one signature")
+	}
+	header := token.Headers[0]
+	if !signatureAlgorithms[header.Algorithm] {
+		return nil, fmt.Errorf("signature algorithm %q not accepted", header.Algorithm)
+	}
+
+	key, err := keys.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+		return nil, fmt.Errorf("signature algorithm %q does not match the key", header.Algorithm)
+	}
+	var claims jwt.Claims
+	var all map[string]any
+	if err := token.Claims(key.Key, &claims, &all); err != nil {
+		return nil, errors.New("invalid signature")
+	}
+
+	if claims.Expiry == nil {
+		return nil, errors.New("token has no expiry")
+	}
+	expected := jwt.Expected{Issuer: settings.Issuer, Audience: jwt.Audience{settings.Audience}, Time: time.Now()}
+	switch err := claims.ValidateWithLeeway(expected, settings.Leeway); {
+	case errors.Is(err, jwt.ErrExpired):
+		return nil, errors.New("token expired")
+	case errors.Is(err, jwt.ErrNotValidYet), errors.Is(err, jwt.ErrIssuedInTheFuture):
+		return nil, errors.New("token not valid yet")
+	case errors.Is(err, jwt.ErrInvalidIssuer):
+		return nil, errors.New("token from another issuer")
+	case errors.Is(err, jwt.ErrInvalidAudience):
+		return nil, errors.New("token for another audience")
+	case err != nil:
+		return nil, errors.New("invalid token")
+	}
+
+	p := &Principal{Subject: claims.Subject, Claims: all}
+	p.User, _ = all[settings.UserClaim].(string)
+	if p.User == "" {
+		p.User = claims.Subject
+	}
+	p.Tenant, _ = all[settings.TenantClaim].(string)
+	return p, nil
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"kindergarten-core/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+const audience = "kindergarten-api"
+
+// testKey signs tokens and is published in the JWKS of testIssuer.
+type testKey struct {
+	kid    string
+	alg    jose.SignatureAlgorithm
+	key    *ecdsa.PrivateKey
+	jwkAlg string
+}
+
+func newTestKey(t *testing.T, kid string) *testKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{kid: kid, alg: jose.ES256, key: key, jwkAlg: string(jose.ES256)}
+}
+
+func (k *testKey) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: k.alg, Key: jose.JSONWebKey{Key: k.key, KeyID: k.kid}},
+		(&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// testIssuer is an OpenID Connect issuer serving a discovery document and
+// the JWKS of its published keys.
+type testIssuer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	published []*testKey
+	fetches   int
+	// block, when set, holds JWKS requests until it is closed
+	block chan struct{}
+	down  bool
+}
+
+func newTestIssuer(t *testing.T, published ...*testKey) *testIssuer {
+	iss := &testIssuer{published: published}
+	iss.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		iss.mu.Lock()
+		block, down := iss.block, iss.down
+		iss.mu.Unlock()
+		if down {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": iss.URL, "jwks_uri": iss.URL + "/jwks.json"})
+		case "/jwks.json":
+			if block != nil {
+				<-block
+			}
+			iss.mu.Lock()
+			defer iss.mu.Unlock()
+			iss.fetches++
+			var set jose.JSONWebKeySet
+			for _, k := range iss.published {
+				set.Keys = append(set.Keys, jose.JSONWebKey{Key: &k.key.PublicKey, KeyID: k.kid, Use: "sig", Algorithm: k.jwkAlg})
+			}
+			json.NewEncoder(w).Encode(set)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+func (iss *testIssuer) publish(keys ...*testKey) {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.published = keys
+}
+
+func (iss *testIssuer) claims(extra map[string]any) map[string]any {
+	claims := map[string]any{
+		"iss": iss.URL, "aud": audience, "sub": "u-1", "preferred_username": "ann", "tenant": "north",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range extra {
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+	}
+	return claims
+}
+
+// initAuth turns authentication on against iss until the test ends.
+func initAuth(t *testing.T, iss *testIssuer) {
+	t.Helper()
+	Init(config.Auth{
+		Issuer: iss.URL, Audience: audience, UserClaim: "preferred_username", TenantClaim: "tenant",
+		Leeway: time.Minute, JWKSCacheTTL: time.Hour,
+	})
+	t.Cleanup(func() { settings, keys = nil, nil })
+}
+
+// allowRefresh lets the next lookup fetch the JWKS without waiting out
+// minRefreshInterval.
+func allowRefresh() {
+	keys.mu.Lock()
+	defer keys.mu.Unlock()
+	keys.attempted = time.Time{}
+}
+
+func request(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/students", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func TestAuthenticate(t *testing.T) {
+	current := newTestKey(t, "current")
+	mismatched := newTestKey(t, "mismatched")
+	mismatched.jwkAlg = string(jose.ES384)
+	iss := newTestIssuer(t, current, mismatched)
+	initAuth(t, iss)
+
+	p, err := Authenticate(request(current.sign(t, iss.claims(nil))))
+	if err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+	if p.Subject != "u-1" || p.User != "ann" || p.Tenant != "north" {
+		t.Errorf("principal = %+v, want subject u-1, user ann, tenant north", p)
+	}
+
+	rogue := newTestKey(t, "current")
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"missing", "", ErrNoToken.Error()},
+		{"malformed", "abc.def.ghi", "malformed token"},
+		{"expired", current.sign(t, iss.claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})), "token expired"},
+		{"no expiry", current.sign(t, iss.claims(map[string]any{"exp": nil})), "token has no expiry"},
+		{"not valid yet", current.sign(t, iss.claims(map[string]any{"nbf": time.Now().Add(time.Hour).Unix()})), "token not valid yet"},
+		{"other issuer", current.sign(t, iss.claims(map[string]any{"iss": "https://elsewhere.example.com"})), "token from another issuer"},
+		{"other audience", current.sign(t, iss.claims(map[string]any{"aud": "billing"})), "token for another audience"},
+		{"no audience", current.sign(t, iss.claims(map[string]any{"aud": nil})), "token for another audience"},
+		{"forged", rogue.sign(t, iss.claims(nil)), "invalid signature"},
+		{"alg not the key's", mismatched.sign(t, iss.claims(nil)), `signature algorithm "ES256" does not match the key`},
+		{"unsigned", unsigned(iss.claims(nil)), `signature algorithm "none" not accepted`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Authenticate(request(tt.token))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// unsigned builds a token with alg "none".
+func unsigned(claims map[string]any) string {
+	encode := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	return encode(map[string]string{"alg": "none", "typ": "JWT"}) + "." + encode(claims) + "."
+}
+
+func TestAuthenticateSymmetricAlgorithm(t *testing.T) {
+	iss := newTestIssuer(t, newTestKey(t, "current"))
+	initAuth(t, iss)
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.HS256, Key: []byte("0123456789abcdef0123456789abcdef")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Signed(signer).Claims(iss.claims(nil)).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(request(token)); err == nil || err.Error() != `signature algorithm "HS256" not accepted` {
+		t.Errorf("error = %v, want HS256 refused", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old, next := newTestKey(t, "old"), newTestKey(t, "next")
+	iss := newTestIssuer(t, old)
+	initAuth(t, iss)
+
+	if _, err := Authenticate(request(old.sign(t, iss.claims(nil)))); err != nil {
+		t.Fatalf("old key: %v", err)
+	}
+
+	iss.publish(next)
+	token := next.sign(t, iss.claims(nil))
+	// the JWKS was fetched moments ago, so the unknown key waits for the next
+	// refresh instead of fetching on every request
+	if _, err := Authenticate(request(token)); err == nil || !strings.Contains(err.Error(), "unknown signing key") {
+		t.Fatalf("rotated key within minRefreshInterval: error = %v, want unknown signing key", err)
+	}
+
+	allowRefresh()
+	if _, err := Authenticate(request(token)); err != nil {
+		t.Fatalf("rotated key: %v", err)
+	}
+	if _, err := Authenticate(request(old.sign(t, iss.claims(nil)))); err == nil {
+		t.Error("retired key still accepted")
+	}
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	if iss.fetches != 2 {
+		t.Errorf("JWKS fetched %d times, want 2", iss.fetches)
+	}
+}
+
+func TestStaleKeysServeWhileRefreshing(t *testing.T) {
+	key := newTestKey(t, "current")
+	iss := newTestIssuer(t, key)
+	initAuth(t, iss)
+	token := key.sign(t, iss.claims(nil))
+	if _, err := Authenticate(request(token)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cache goes stale and the issuer hangs on the next fetch
+	block := make(chan struct{})
+	iss.mu.Lock()
+	iss.block = block
+	iss.mu.Unlock()
+	keys.mu.Lock()
+	keys.fetched, keys.attempted = time.Time{}, time.Time{}
+	keys.mu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := Authenticate(request(token))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("cached key: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("request waited for the JWKS fetch although its key was cached")
+	}
+	close(block)
+}
+
+func TestKeysUnavailable(t *testing.T) {
+	key := newTestKey(t, "current")
+	iss := newTestIssuer(t, key)
+	iss.down = true
+	initAuth(t, iss)
+
+	_, err := Authenticate(request(key.sign(t, iss.claims(nil))))
+	if !errors.Is(err, ErrKeysUnavailable) {
+		t.Errorf("error = %v, want ErrKeysUnavailable", err)
+	}
+}
+
+func TestKeyWaitHonoursContext(t *testing.T) {
+	key := newTestKey(t, "current")
+	iss := newTestIssuer(t, key)
+	iss.block = make(chan struct{})
+	defer close(iss.block)
+	initAuth(t, iss)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	r := request(key.sign(t, iss.claims(nil))).WithContext(ctx)
+	if _, err := Authenticate(r); !errors.Is(err, ErrKeysUnavailable) {
+		t.Errorf("error = %v, want ErrKeysUnavailable once the request gives up", err)
+	}
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// minRefreshInterval keeps tokens with made-up key IDs from making the
+	// service fetch the JWKS on every request.
+	minRefreshInterval = 30 * time.Second
+	fetchTimeout       = 10 * time.Second
+)
+
+// keySet caches the signing keys of a JWKS. mu only guards the cache; the
+// JWKS is fetched without holding it, once for all requests that need it.
+type keySet struct {
+	issuer string
+	ttl    time.Duration
+	client *http.Client
+	group  singleflight.Group
+
+	mu sync.Mutex
+	// url is found through the discovery document of the issuer when not
+	// configured
+	url       string
+	keys      jose.JSONWebKeySet
+	fetched   time.Time
+	attempted time.Time
+}
+
+func newKeySet(issuer, url string, ttl time.Duration) *keySet {
+	return &keySet{issuer: issuer, url: url, ttl: ttl, client: &http.Client{Timeout: fetchTimeout}}
+}
+
+// key returns the key called kid. A stale JWKS is fetched again in the
+// background while the cached keys keep serving; only a kid the cache lacks,
+// as after the issuer rotated its keys, waits for a fetch. The JWKS is
+// fetched at most once per minRefreshInterval.
+func (ks *keySet) key(ctx context.Context, kid string) (*jose.JSONWebKey, error) {
+	ks.mu.Lock()
+	key := ks.lookup(kid)
+	stale := time.Since(ks.fetched) > ks.ttl
+	ks.mu.Unlock()
+
+	if key != nil {
+		if stale {
+			ks.refresh(ctx)
+		}
+		return key, nil
+	}
+
+	select {
+	case <-ks.refresh(ctx):
+	case <-ctx.Done():
+		return nil, ErrKeysUnavailable
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if key := ks.lookup(kid); key != nil {
+		return key, nil
+	}
+	if len(ks.keys.Keys) == 0 {
+		return nil, ErrKeysUnavailable
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup finds a signing key by ID. Tokens without a key ID are accepted
+// from issuers with a single key. The caller holds mu.
+func (ks *keySet) lookup(kid string) *jose.JSONWebKey {
+	var found []jose.JSONWebKey
+	for _, key := range ks.keys.Keys {
+		if key.Use == "enc" {
+			continue
+		}
+		if key.KeyID == kid || kid == "" {
+			found = append(found, key)
+		}
+	}
+	if len(found) != 1 {
+		return nil
+	}
+	return &found[0]
+}
+
+// refresh fetches the JWKS unless a fetch is running already, which it joins,
+// or the last attempt was less than minRefreshInterval ago. The channel
+// delivers once the fetch is done. On failure the keys fetched before stay in
+// use until a later attempt succeeds.
+func (ks *keySet) refresh(ctx context.Context) <-chan singleflight.Result {
+	// the fetch outlives the request that started it
+	ctx = context.WithoutCancel(ctx)
+	return ks.group.DoChan("jwks", func() (any, error) {
+		ks.mu.Lock()
+		if time.Since(ks.attempted) < minRefreshInterval {
+			ks.mu.Unlock()
+			return nil, nil
+		}
+		ks.attempted = time.Now()
+		url := ks.url
+		ks.mu.Unlock()
+
+		keys, url, err := ks.fetch(ctx, url)
+		if err != nil {
+			slog.WarnContext(ctx, "Fetching signing keys failed", "url", url, "error", err)
+			return nil, err
+		}
+		ks.mu.Lock()
+		ks.url, ks.keys, ks.fetched = url, keys, time.Now()
+		ks.mu.Unlock()
+		slog.InfoContext(ctx, "Fetched signing keys", "url", url, "keys", len(keys.Keys))
+		return nil, nil
+	})
+}
+
+// fetch reads the JWKS at url, or at the one the discovery document of the
+// issuer names when url is empty, and returns it with the URL it came from.
+func (ks *keySet) fetch(ctx context.Context, url string) (jose.JSONWebKeySet, string, error) {
+	var keys jose.JSONWebKeySet
+	if url == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := ks.getJSON(ctx, strings.TrimSuffix(ks.issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return keys, url, fmt.Errorf("discovery: %w", err)
+		}
+		if discovery.JWKSURI == "" {
+			return keys, url, errors.New("discovery: no jwks_uri")
+		}
+		url = discovery.JWKSURI
+	}
+	err := ks.getJSON(ctx, url, &keys)
+	return keys, url, err
+}
+
+func (ks *keySet) getJSON(ctx context.Context, url string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,244 @@
+// Package config loads the settings of a service in layers: built-in
+// defaults, then an optional YAML file, then environment variables, then
+// command-line flags, each overriding the one before.
+//
+// Every setting is a field of Config whose yaml tags give its path in the
+// file (mongo.uri), whose env tag names its environment variable
+// (MONGODB_URI) and whose flag is the path with dashes (--mongo-uri).
+// Fields tagged secret are redacted by --print-config.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// Config is the configuration of a service.
+type Config struct {
+	Service Service `yaml:"service"`
+	Log     Log     `yaml:"log"`
+	Auth    Auth    `yaml:"auth"`
+	Mongo   Mongo   `yaml:"mongo"`
+	Trash   Trash   `yaml:"trash"`
+	Tracing Tracing `yaml:"tracing"`
+	APM     APM     `yaml:"apm"`
+	Vault   Vault   `yaml:"vault"`
+}
+
+type Service struct {
+	Name   string `yaml:"name" env:"SERVICE_NAME" usage:"service name used in logs and APM"`
+	Port   int    `yaml:"port" env:"SERVICE_PORT" usage:"port the HTTP server listens on"`
+	Prefix string `yaml:"prefix" env:"SERVICE_PREFIX" usage:"path prefix in front of every route, e.g. /std"`
+	// ShutdownDelay gives load balancers time to notice the service is not
+	// ready before it stops accepting connections.
+	ShutdownDelay   time.Duration `yaml:"shutdown_delay" env:"SHUTDOWN_DELAY" usage:"how long to report not-ready before draining on SIGTERM"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"deadline for in-flight requests to finish on SIGTERM"`
+	HealthTimeout   time.Duration `yaml:"health_timeout" env:"HEALTH_TIMEOUT" usage:"timeout for each readiness check"`
+}
+
+// Auth turns on bearer token authentication when Issuer or JWKSURL is set.
+// Tokens are JWTs signed with a key from the JWKS, which is found through the
+// issuer's OpenID Connect discovery document unless JWKSURL names it.
+type Auth struct {
+	Issuer       string        `yaml:"issuer" env:"AUTH_ISSUER" usage:"OIDC issuer URL tokens must name in iss; required with jwks_url"`
+	JWKSURL      string        `yaml:"jwks_url" env:"AUTH_JWKS_URL" usage:"URL of the JWKS holding the signing keys; empty uses the issuer's discovery document"`
+	Audience     string        `yaml:"audience" env:"AUTH_AUDIENCE" usage:"audience tokens must name in aud; required once authentication is on"`
+	UserClaim    string        `yaml:"user_claim" env:"AUTH_USER_CLAIM" usage:"claim naming the user in logs, the audit log and APM; sub when absent"`
+	TenantClaim  string        `yaml:"tenant_claim" env:"AUTH_TENANT_CLAIM" usage:"claim naming the kindergarten branch of the user"`
+	Leeway       time.Duration `yaml:"leeway" env:"AUTH_LEEWAY" usage:"clock skew tolerated when checking exp and nbf"`
+	JWKSCacheTTL time.Duration `yaml:"jwks_cache_ttl" env:"AUTH_JWKS_CACHE_TTL" usage:"how long fetched keys are used before the JWKS is fetched again"`
+}
+
+type Mongo struct {
+	URI            string        `yaml:"uri" env:"MONGODB_URI" secret:"true" usage:"MongoDB connection string"`
+	Username       string        `yaml:"username" env:"MONGODB_USERNAME" usage:"MongoDB user, replacing the one in the URI"`
+	Password       string        `yaml:"password" env:"MONGODB_PASSWORD" secret:"true" usage:"password of the MongoDB user"`
+	Database       string        `yaml:"database" env:"DATABASE_NAME" usage:"MongoDB database name"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout" env:"MONGODB_CONNECT_TIMEOUT" usage:"timeout of each MongoDB connection attempt"`
+	// Startup retries the first connection with exponential backoff from
+	// RetryInitial up to RetryMax until StartupBudget is spent.
+	RetryInitial  time.Duration `yaml:"retry_initial" env:"MONGODB_RETRY_INITIAL" usage:"first delay between MongoDB connection attempts"`
+	RetryMax      time.Duration `yaml:"retry_max" env:"MONGODB_RETRY_MAX" usage:"longest delay between MongoDB connection attempts"`
+	StartupBudget time.Duration `yaml:"startup_budget" env:"MONGODB_STARTUP_BUDGET" usage:"how long to keep trying to reach MongoDB at startup"`
+	DegradedStart bool          `yaml:"degraded_start" env:"MONGODB_DEGRADED_START" usage:"start without MongoDB once the budget is spent and connect lazily"`
+	// Migrate runs the service as a one-off job that creates the indexes
+	// instead of serving.
+	Migrate bool `yaml:"migrate" env:"MONGODB_MIGRATE" usage:"create the indexes, list the records blocking them and exit instead of serving"`
+}
+
+type Trash struct {
+	Retention     time.Duration `yaml:"retention" env:"TRASH_RETENTION" usage:"how long deleted records stay in the trash"`
+	PurgeInterval time.Duration `yaml:"purge_interval" env:"TRASH_PURGE_INTERVAL" usage:"how often the trash is purged"`
+}
+
+// Log controls the structured log written to stderr.
+type Log struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" usage:"lowest level logged: debug, info, warn or error"`
+	Format string `yaml:"format" env:"LOG_FORMAT" usage:"json or text"`
+	Access bool   `yaml:"access" env:"LOG_ACCESS" usage:"log every request"`
+}
+
+// Tracing chooses where spans are exported. The OTLP exporter reads the rest
+// of the OTEL_EXPORTER_OTLP_* variables, such as headers, itself.
+type Tracing struct {
+	Exporter     string `yaml:"exporter" env:"TRACING_EXPORTER" usage:"where traces go: elastic, otlp, both or none"`
+	OTLPEndpoint string `yaml:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" usage:"OTLP collector URL; http:// disables TLS; empty uses the exporter's default"`
+	OTLPProtocol string `yaml:"otlp_protocol" env:"OTEL_EXPORTER_OTLP_PROTOCOL" usage:"OTLP protocol: grpc or http/protobuf"`
+	Tenant       string `yaml:"tenant" env:"TRACING_TENANT" usage:"kindergarten branch traces are labelled with unless the bearer token names one"`
+}
+
+// APM settings are handed to the Elastic agent, which reads the rest of its
+// ELASTIC_APM_* variables itself.
+type APM struct {
+	ServerURL   string `yaml:"server_url" env:"ELASTIC_APM_SERVER_URL" usage:"Elastic APM server URL"`
+	SecretToken string `yaml:"secret_token" env:"ELASTIC_APM_SECRET_TOKEN" secret:"true" usage:"Elastic APM secret token"`
+}
+
+// Vault is where secrets come from when Address is set. Values found in Vault
+// replace the ones from the file, environment and flags; anything Vault does
+// not have keeps them.
+type Vault struct {
+	Address             string        `yaml:"address" env:"VAULT_ADDR" usage:"Vault address; empty disables Vault"`
+	AuthMethod          string        `yaml:"auth_method" env:"VAULT_AUTH_METHOD" usage:"kubernetes, approle or token"`
+	Role                string        `yaml:"role" env:"VAULT_ROLE" usage:"Vault role for Kubernetes auth"`
+	KubernetesMount     string        `yaml:"kubernetes_mount" env:"VAULT_KUBERNETES_MOUNT" usage:"mount path of the Kubernetes auth method"`
+	KubernetesTokenPath string        `yaml:"kubernetes_token_path" env:"VAULT_KUBERNETES_TOKEN_PATH" usage:"service account token used for Kubernetes auth"`
+	RoleID              string        `yaml:"role_id" env:"VAULT_ROLE_ID" usage:"AppRole role ID"`
+	SecretID            string        `yaml:"secret_id" env:"VAULT_SECRET_ID" secret:"true" usage:"AppRole secret ID"`
+	AppRoleMount        string        `yaml:"approle_mount" env:"VAULT_APPROLE_MOUNT" usage:"mount path of the AppRole auth method"`
+	Token               string        `yaml:"token" env:"VAULT_TOKEN" secret:"true" usage:"Vault token for token auth"`
+	KVMount             string        `yaml:"kv_mount" env:"VAULT_KV_MOUNT" usage:"mount path of the KV v2 secrets engine"`
+	MongoPath           string        `yaml:"mongo_path" env:"VAULT_MONGO_PATH" usage:"KV secret holding the MongoDB uri"`
+	APMPath             string        `yaml:"apm_path" env:"VAULT_APM_PATH" usage:"KV secret holding the APM server_url and secret_token"`
+	DatabaseMount       string        `yaml:"database_mount" env:"VAULT_DATABASE_MOUNT" usage:"mount path of the database secrets engine"`
+	MongoRole           string        `yaml:"mongo_role" env:"VAULT_MONGO_ROLE" usage:"database secrets engine role for short-lived MongoDB users; empty uses static credentials"`
+	SkipVerify          bool          `yaml:"skip_verify" env:"VAULT_SKIP_VERIFY" usage:"skip TLS certificate verification"`
+	Timeout             time.Duration `yaml:"timeout" env:"VAULT_TIMEOUT" usage:"timeout for Vault requests"`
+}
+
+// Defaults returns the built-in configuration of the service called name
+// listening on port.
+func Defaults(name string, port int) Config {
+	return Config{
+		Service: Service{
+			Name:            name,
+			Port:            port,
+			ShutdownTimeout: 20 * time.Second,
+			HealthTimeout:   2 * time.Second,
+		},
+		Log: Log{Level: "info", Format: "json", Access: true},
+		Auth: Auth{
+			UserClaim:    "preferred_username",
+			TenantClaim:  "tenant",
+			Leeway:       time.Minute,
+			JWKSCacheTTL: time.Hour,
+		},
+		Mongo: Mongo{
+			Database:       "kindergarten",
+			ConnectTimeout: 10 * time.Second,
+			RetryInitial:   500 * time.Millisecond,
+			RetryMax:       15 * time.Second,
+			StartupBudget:  time.Minute,
+		},
+		Trash:   Trash{Retention: 30 * 24 * time.Hour, PurgeInterval: time.Hour},
+		Tracing: Tracing{Exporter: "elastic", OTLPProtocol: "grpc"},
+		Vault: Vault{
+			AuthMethod:          "kubernetes",
+			Role:                "kindergarten-role",
+			KubernetesMount:     "kubernetes",
+			KubernetesTokenPath: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+			AppRoleMount:        "approle",
+			KVMount:             "kindergarten/config",
+			MongoPath:           "mongodb",
+			APMPath:             "apm",
+			DatabaseMount:       "database",
+			Timeout:             10 * time.Second,
+		},
+	}
+}
+
+// Load builds the configuration from defaults, the YAML file named by
+// --config or CONFIG_FILE, the environment and args, the command line without
+// the program name. print reports whether --print-config was given. The error
+// lists every problem found, not just the first.
+func Load(defaults Config, args []string) (cfg *Config, print bool, err error) {
+	cfg = &defaults
+	settings := settingsOf(cfg)
+
+	flags := flag.NewFlagSet("service", flag.ContinueOnError)
+	file := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration `file` (env CONFIG_FILE)")
+	flags.BoolVar(&print, "print-config", false, "print the effective configuration with secrets redacted and exit")
+	given := map[string]string{}
+	for _, s := range settings {
+		name := s.flag
+		flags.Func(name, s.usage+" (env "+s.env+")", func(value string) error {
+			given[name] = value
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	var problems []error
+	if *file != "" {
+		if err := loadFile(cfg, *file); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok && value != "" {
+			if err := s.set(value); err != nil {
+				problems = append(problems, fmt.Errorf("%s: %v", s.env, err))
+			}
+		}
+	}
+	for _, s := range settings {
+		if value, ok := given[s.flag]; ok {
+			if err := s.set(value); err != nil {
+				problems = append(problems, fmt.Errorf("--%s: %v", s.flag, err))
+			}
+		}
+	}
+	problems = append(problems, cfg.validate()...)
+	return cfg, print, errors.Join(problems...)
+}
+
+// MustLoad is Load for main: it reads os.Args, exits after printing the
+// configuration for --print-config and exits listing every problem when the
+// configuration is invalid.
+func MustLoad(defaults Config) *Config {
+	cfg, print, err := Load(defaults, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if print {
+		if printErr := cfg.Print(os.Stdout); printErr != nil {
+			log.Fatal("Printing configuration failed: ", printErr)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nInvalid configuration:\n%v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	return cfg
+}
+
+// Print writes the configuration as YAML with secrets redacted.
+func (cfg *Config) Print(w io.Writer) error {
+	out, err := cfg.redactedYAML()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is one leaf field of Config.
+type setting struct {
+	path   string // service.port
+	env    string
+	flag   string // service-port
+	usage  string
+	secret bool
+	value  reflect.Value
+}
+
+// settingsOf lists the settings of cfg in declaration order.
+func settingsOf(cfg *Config) []setting {
+	var settings []setting
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			path := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+				walk(v.Field(i), path+".")
+				continue
+			}
+			settings = append(settings, setting{
+				path:   path,
+				env:    field.Tag.Get("env"),
+				flag:   strings.NewReplacer(".", "-", "_", "-").Replace(path),
+				usage:  field.Tag.Get("usage"),
+				secret: field.Tag.Get("secret") == "true",
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return settings
+}
+
+// set parses value into the setting.
+func (s setting) set(value string) error {
+	switch {
+	case s.value.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 90s or 1h", value)
+		}
+		s.value.SetInt(int64(d))
+	case s.value.Kind() == reflect.String:
+		s.value.SetString(value)
+	case s.value.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", value)
+		}
+		s.value.SetInt(int64(n))
+	case s.value.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		s.value.SetBool(b)
+	default:
+		panic("config: unsupported type " + s.value.Type().String())
+	}
+	return nil
+}
+
+// loadFile overlays the YAML file on cfg. Keys the file leaves out keep their
+// current value; unknown keys are an error so typos do not go unnoticed.
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %v", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// validate returns every problem with the configuration, naming each setting
+// by all the ways it can be given.
+func (cfg *Config) validate() []error {
+	var problems []error
+	check := func(ok bool, path, format string, args ...any) {
+		if ok {
+			return
+		}
+		for _, s := range settingsOf(cfg) {
+			if s.path == path {
+				problems = append(problems, fmt.Errorf("%s (%s, --%s): %s", path, s.env, s.flag, fmt.Sprintf(format, args...)))
+			}
+		}
+	}
+
+	check(cfg.Service.Name != "", "service.name", "is required")
+	check(cfg.Service.Port > 0 && cfg.Service.Port <= 65535, "service.port", "must be between 1 and 65535, got %d", cfg.Service.Port)
+	check(cfg.Service.Prefix == "" || strings.HasPrefix(cfg.Service.Prefix, "/") && !strings.HasSuffix(cfg.Service.Prefix, "/"),
+		"service.prefix", "must start and not end with /, got %q", cfg.Service.Prefix)
+	check(cfg.Service.ShutdownDelay >= 0, "service.shutdown_delay", "must not be negative, got %s", cfg.Service.ShutdownDelay)
+	check(cfg.Service.ShutdownTimeout > 0, "service.shutdown_timeout", "must be positive, got %s", cfg.Service.ShutdownTimeout)
+	check(cfg.Service.HealthTimeout > 0, "service.health_timeout", "must be positive, got %s", cfg.Service.HealthTimeout)
+
+	switch cfg.Log.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "log.level", "must be debug, info, warn or error, got %q", cfg.Log.Level)
+	}
+	check(cfg.Log.Format == "json" || cfg.Log.Format == "text", "log.format", "must be json or text, got %q", cfg.Log.Format)
+
+	check(cfg.Auth.Issuer == "" || strings.HasPrefix(cfg.Auth.Issuer, "http://") || strings.HasPrefix(cfg.Auth.Issuer, "https://"),
+		"auth.issuer", "must start with http:// or https://, got %q", cfg.Auth.Issuer)
+	check(cfg.Auth.JWKSURL == "" || strings.HasPrefix(cfg.Auth.JWKSURL, "http://") || strings.HasPrefix(cfg.Auth.JWKSURL, "https://"),
+		"auth.jwks_url", "must start with http:// or https://, got %q", cfg.Auth.JWKSURL)
+	if cfg.Auth.Issuer != "" || cfg.Auth.JWKSURL != "" {
+		// without them any issuer's tokens, or tokens for any service, would pass
+		check(cfg.Auth.Issuer != "", "auth.issuer", "is required when authentication is on")
+		check(cfg.Auth.Audience != "", "auth.audience", "is required when authentication is on")
+	}
+	check(cfg.Auth.Leeway >= 0, "auth.leeway", "must not be negative, got %s", cfg.Auth.Leeway)
+	check(cfg.Auth.JWKSCacheTTL > 0, "auth.jwks_cache_ttl", "must be positive, got %s", cfg.Auth.JWKSCacheTTL)
+
+	// with Vault the URI can still come from there; secrets.Apply checks it
+	check(cfg.Mongo.URI != "" || cfg.Vault.Address != "", "mongo.uri", "is required")
+	if cfg.Mongo.URI != "" {
+		check(strings.HasPrefix(cfg.Mongo.URI, "mongodb://") || strings.HasPrefix(cfg.Mongo.URI, "mongodb+srv://"),
+			"mongo.uri", "must start with mongodb:// or mongodb+srv://")
+	}
+	check(cfg.Mongo.Database != "", "mongo.database", "is required")
+	check(cfg.Mongo.ConnectTimeout > 0, "mongo.connect_timeout", "must be positive, got %s", cfg.Mongo.ConnectTimeout)
+	check(cfg.Mongo.RetryInitial > 0, "mongo.retry_initial", "must be positive, got %s", cfg.Mongo.RetryInitial)
+	check(cfg.Mongo.RetryMax >= cfg.Mongo.RetryInitial, "mongo.retry_max", "must be at least mongo.retry_initial, got %s", cfg.Mongo.RetryMax)
+	check(cfg.Mongo.StartupBudget >= 0, "mongo.startup_budget", "must not be negative, got %s", cfg.Mongo.StartupBudget)
+
+	check(cfg.Trash.Retention > 0, "trash.retention", "must be positive, got %s", cfg.Trash.Retention)
+	check(cfg.Trash.PurgeInterval > 0, "trash.purge_interval", "must be positive, got %s", cfg.Trash.PurgeInterval)
+	switch cfg.Tracing.Exporter {
+	case "elastic", "otlp", "both", "none":
+	default:
+		check(false, "tracing.exporter", "must be elastic, otlp, both or none, got %q", cfg.Tracing.Exporter)
+	}
+	check(cfg.Tracing.OTLPProtocol == "grpc" || cfg.Tracing.OTLPProtocol == "http/protobuf",
+		"tracing.otlp_protocol", "must be grpc or http/protobuf, got %q", cfg.Tracing.OTLPProtocol)
+	check(cfg.Tracing.OTLPEndpoint == "" || strings.HasPrefix(cfg.Tracing.OTLPEndpoint, "http://") || strings.HasPrefix(cfg.Tracing.OTLPEndpoint, "https://"),
+		"tracing.otlp_endpoint", "must start with http:// or https://, got %q", cfg.Tracing.OTLPEndpoint)
+	if cfg.Vault.Address != "" {
+		check(strings.HasPrefix(cfg.Vault.Address, "http://") || strings.HasPrefix(cfg.Vault.Address, "https://"),
+			"vault.address", "must start with http:// or https://, got %q", cfg.Vault.Address)
+		switch cfg.Vault.AuthMethod {
+		case "kubernetes":
+			check(cfg.Vault.Role != "", "vault.role", "is required for kubernetes auth")
+			check(cfg.Vault.KubernetesTokenPath != "", "vault.kubernetes_token_path", "is required for kubernetes auth")
+		case "approle":
+			check(cfg.Vault.RoleID != "", "vault.role_id", "is required for approle auth")
+			check(cfg.Vault.SecretID != "", "vault.secret_id", "is required for approle auth")
+		case "token":
+			check(cfg.Vault.Token != "", "vault.token", "is required for token auth")
+		default:
+			check(false, "vault.auth_method", "must be kubernetes, approle or token, got %q", cfg.Vault.AuthMethod)
+		}
+		check(cfg.Vault.KVMount != "", "vault.kv_mount", "is required")
+		check(cfg.Vault.Timeout > 0, "vault.timeout", "must be positive, got %s", cfg.Vault.Timeout)
+	}
+	return problems
+}
+
+// redactedYAML renders cfg in the layout of the config file. Secrets keep
+// enough to recognise them: a URI keeps everything but its password, anything
+// else is replaced.
+func (cfg *Config) redactedYAML() ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	sections := map[string]*yaml.Node{}
+	for _, s := range settingsOf(cfg) {
+		section, key, _ := strings.Cut(s.path, ".")
+		node := sections[section]
+		if node == nil {
+			node = &yaml.Node{Kind: yaml.MappingNode}
+			sections[section] = node
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: section}, node)
+		}
+
+		var value yaml.Node
+		switch {
+		case s.secret:
+			value.SetString(redact(s.value.String()))
+		case s.value.Type() == durationType:
+			value.SetString(time.Duration(s.value.Int()).String())
+		default:
+			if err := value.Encode(s.value.Interface()); err != nil {
+				return nil, err
+			}
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &value)
+	}
+	return yaml.Marshal(root)
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if u, err := url.Parse(secret); err == nil && u.Scheme != "" && u.Host != "" {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			return u.Redacted()
+		}
+		return u.String()
+	}
+	return "REDACTED"
+}
@@ -0,0 +1,481 @@
+package crud
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kindergarten-core/auth"
+	"kindergarten-core/database"
+	"kindergarten-core/logging"
+	"kindergarten-core/metrics"
+	"kindergarten-core/tracing"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The audit log is shared by all services. Every entry stores the hash of the
+// entry before it, so editing or removing an entry breaks the chain from that
+// point on. seq is unique, which keeps concurrent writers from forking the chain.
+const (
+	auditCollection    = "audit_log"
+	auditInsertRetries = 10
+	auditRetryDelay    = 5 * time.Millisecond
+	auditVerifyTimeout = 5 * time.Minute
+)
+
+// auditIndexes are created by EnsureIndexes.
+var auditIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "seq", Value: 1}}, Options: options.Index().SetUnique(true)},
+	{Keys: bson.D{{Key: "entity", Value: 1}, {Key: "entity_key", Value: 1}}},
+}
+
+// auditEvent is one change to record in the audit log.
+type auditEvent struct {
+	entity, key, action string
+	actor, requestID    string
+	before, after       interface{}
+}
+
+// newAuditEvent describes a change made by r.
+func newAuditEvent(r *http.Request, entity, key, action string, before, after interface{}) auditEvent {
+	return auditEvent{
+		entity: entity, key: key, action: action,
+		actor: actor(r), requestID: logging.RequestID(r.Context()),
+		before: before, after: after,
+	}
+}
+
+// recordAudit appends an entry for a mutation made by r to the audit log.
+// The change has been made by then, so when that fails it cannot be undone;
+// instead r gets a 500 audit_failed problem saying so, and recordAudit
+// returns false.
+func recordAudit(ctx context.Context, w http.ResponseWriter, r *http.Request, entity, key, action string, before, after interface{}) bool {
+	if err := writeAudit(ctx, newAuditEvent(r, entity, key, action, before, after)); err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, codeAuditFailed, "The change was saved, but its audit entry could not be written")
+		return false
+	}
+	return true
+}
+
+// writeAudit appends events to the audit log in one batch. Failures are
+// logged, captured and counted in audit_failures_total.
+func writeAudit(ctx context.Context, events ...auditEvent) error {
+	err := appendAuditEntries(ctx, openAuditStore(ctx), events)
+	if err != nil {
+		for _, event := range events {
+			metrics.AuditFailure(event.entity, event.action)
+		}
+		tracing.CaptureError(ctx, err)
+		slog.ErrorContext(ctx, "Audit entries failed", "entries", len(events), "action", events[0].action, "entity", events[0].entity,
+			"key", events[0].key, "error", err)
+	}
+	return err
+}
+
+// openAuditStore returns the audit log of the connection ctx uses; tests
+// replace it.
+var openAuditStore = func(ctx context.Context) auditStore {
+	return mongoAuditStore{database.Collection(ctx, auditCollection)}
+}
+
+// auditStore holds the entries of the audit log.
+type auditStore interface {
+	// last returns the seq and hash of the newest entry, zero for none.
+	last(ctx context.Context) (seq int64, hash string, err error)
+	// insert inserts entries in order. When one of them has a seq that is
+	// taken it stops there and returns how many went in before it.
+	insert(ctx context.Context, entries []bson.M) (inserted int, collided bool, err error)
+}
+
+// mongoAuditStore is the audit_log collection, whose unique seq index
+// reports the collisions.
+type mongoAuditStore struct {
+	collection *mongo.Collection
+}
+
+func (s mongoAuditStore) last(ctx context.Context) (int64, string, error) {
+	var last struct {
+		Seq  int64  `bson:"seq"`
+		Hash string `bson:"hash"`
+	}
+	err := s.collection.FindOne(ctx, bson.M{}, options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		err = nil
+	}
+	return last.Seq, last.Hash, err
+}
+
+func (s mongoAuditStore) insert(ctx context.Context, entries []bson.M) (int, bool, error) {
+	docs := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		docs[i] = entry
+	}
+	_, err := s.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(true))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) == 1 && mongo.IsDuplicateKeyError(bulkErr.WriteErrors[0]) {
+		return bulkErr.WriteErrors[0].Index, true, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return len(entries), false, nil
+}
+
+// appendAuditEntries chains events onto the last entry and inserts them in
+// order. When another writer took one of their seq numbers the rest are
+// chained onto its entry instead, after a short, growing pause.
+func appendAuditEntries(ctx context.Context, store auditStore, events []auditEvent) error {
+	// Mongo keeps milliseconds, so truncate before hashing
+	timestamp := time.Now().UTC().Truncate(time.Millisecond)
+
+	for attempt := 0; attempt < auditInsertRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * auditRetryDelay):
+			}
+		}
+
+		seq, hash, err := store.last(ctx)
+		if err != nil {
+			return err
+		}
+		entries := make([]bson.M, len(events))
+		for i, event := range events {
+			seq++
+			if entries[i], err = newAuditEntry(seq, hash, timestamp, event); err != nil {
+				return err
+			}
+			hash = entries[i]["hash"].(string)
+		}
+
+		inserted, collided, err := store.insert(ctx, entries)
+		if !collided {
+			return err
+		}
+		// the entries before the collision are in
+		events = events[inserted:]
+	}
+	return errors.New("audit log is busy, giving up")
+}
+
+// newAuditEntry is the document recording event as entry seq, chained onto
+// the entry with hash prevHash.
+func newAuditEntry(seq int64, prevHash string, timestamp time.Time, event auditEvent) (bson.M, error) {
+	entry, err := toDocument(bson.M{
+		"seq":        seq,
+		"prev_hash":  prevHash,
+		"timestamp":  timestamp,
+		"entity":     event.entity,
+		"entity_key": event.key,
+		"action":     event.action,
+		"actor":      event.actor,
+		"request_id": event.requestID,
+		"before":     event.before,
+		"after":      event.after,
+	})
+	if err != nil {
+		return nil, err
+	}
+	entry["hash"], err = auditHash(entry)
+	return entry, err
+}
+
+// auditHash is the SHA-256 of the entry's canonical JSON without _id and hash.
+// Verifiers recompute it from the stored document the same way.
+func auditHash(entry bson.M) (string, error) {
+	doc := bson.M{}
+	for k, v := range entry {
+		if k != "_id" && k != "hash" {
+			doc[k] = v
+		}
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// toDocument converts v to the bson.M a reader would get back from Mongo.
+func toDocument(v interface{}) (bson.M, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	doc := bson.M{}
+	err = bson.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+// applyUpdate works out the document an update produced from the document it
+// replaced, so writes can return the old version and still report the new one.
+func applyUpdate(before interface{}, update bson.M, after interface{}) {
+	doc, err := toDocument(before)
+	if err == nil {
+		for operator, fields := range update {
+			var values bson.M
+			if values, err = toDocument(fields); err != nil {
+				break
+			}
+			for field, value := range values {
+				switch operator {
+				case "$set":
+					setPath(doc, field, value)
+				case "$unset":
+					unsetPath(doc, field)
+				case "$inc":
+					doc[field] = toInt64(doc[field]) + toInt64(value)
+				}
+			}
+		}
+	}
+	if err == nil {
+		var raw []byte
+		if raw, err = bson.Marshal(doc); err == nil {
+			// start from a zero value so removed fields do not linger
+			target := reflect.ValueOf(after).Elem()
+			target.Set(reflect.Zero(target.Type()))
+			err = bson.Unmarshal(raw, after)
+		}
+	}
+	if err != nil {
+		slog.Error("Applying update to audit document failed", "error", err)
+	}
+}
+
+func setPath(doc bson.M, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := doc[part].(bson.M)
+		if !ok {
+			next = bson.M{}
+			doc[part] = next
+		}
+		doc = next
+	}
+	doc[parts[len(parts)-1]] = value
+}
+
+func unsetPath(doc bson.M, path string) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := doc[part].(bson.M)
+		if !ok {
+			return
+		}
+		doc = next
+	}
+	delete(doc, parts[len(parts)-1])
+}
+
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case int:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+// actor names who made the request: the user of its bearer token, or
+// anonymous. Request headers are never trusted for it, so the audit log only
+// names users the issuer vouched for.
+func actor(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p != nil {
+		return p.User
+	}
+	return "anonymous"
+}
+
+// GetAuditLog lists audit entries, newest first. Filters: entity, key, actor,
+// action, from and to (RFC 3339), plus the usual limit and cursor parameters.
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query, err := parseListQuery(r, []string{"seq"}, "-seq")
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+
+	var invalid *FieldError
+	if query.Filter, invalid = auditFilter(r.URL.Query()); invalid != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeValidationFailed, invalid.Field+" "+invalid.Message, *invalid)
+		return
+	}
+
+	span, ctx := tracing.StartSpan(r.Context(), "GetAuditLogFromDB", "app")
+	defer span.End()
+
+	collection := database.Collection(ctx, auditCollection)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	total, err := collection.CountDocuments(ctx, query.Filter)
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+
+	cursor, err := collection.Find(ctx, query.findFilter(), query.findOptions())
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var found []bson.Raw
+	if err = cursor.All(ctx, &found); err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+	found, next := query.page(found)
+	entries := make([]bson.M, len(found))
+	for i, doc := range found {
+		if err = bson.Unmarshal(doc, &entries[i]); err != nil {
+			tracing.CaptureError(r.Context(), err)
+			writeDBError(w, r, err)
+			return
+		}
+		// the cursor needs _id, clients do not
+		delete(entries[i], "_id")
+	}
+
+	writePageHeaders(w, r, query, total, len(entries), next)
+	json.NewEncoder(w).Encode(entries)
+}
+
+// auditFilter builds the filter of GetAuditLog from its parameters.
+func auditFilter(params url.Values) (bson.M, *FieldError) {
+	filter := bson.M{}
+	for param, field := range map[string]string{"entity": "entity", "key": "entity_key", "actor": "actor", "action": "action"} {
+		if v := params.Get(param); v != "" {
+			filter[field] = v
+		}
+	}
+	timeRange := bson.M{}
+	for param, operator := range map[string]string{"from": "$gte", "to": "$lte"} {
+		if v := params.Get(param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, &FieldError{Field: param, Code: "format", Message: "must be an RFC 3339 timestamp"}
+			}
+			timeRange[operator] = t
+		}
+	}
+	if len(timeRange) > 0 {
+		filter["timestamp"] = timeRange
+	}
+	return filter, nil
+}
+
+// AuditVerification is the result of checking the hash chain of the audit log.
+// BrokenAt is the seq of the first entry that does not fit the chain.
+type AuditVerification struct {
+	Entries  int64  `json:"entries"`
+	Valid    bool   `json:"valid"`
+	BrokenAt int64  `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// VerifyAuditLog walks the audit log in seq order and checks that the seq
+// numbers have no gaps, that every entry names the hash of the one before it
+// and that its own hash matches its content. It stops at the first entry that
+// fails and reports it.
+func VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	span, ctx := tracing.StartSpan(r.Context(), "VerifyAuditLogFromDB", "app")
+	defer span.End()
+
+	collection := database.Collection(ctx, auditCollection)
+	ctx, cancel := context.WithTimeout(ctx, auditVerifyTimeout)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "seq", Value: 1}}).SetBatchSize(exportBatchSize))
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	chain := auditChain{result: AuditVerification{Valid: true}}
+	for cursor.Next(ctx) {
+		entry := bson.M{}
+		if err := cursor.Decode(&entry); err != nil {
+			tracing.CaptureError(r.Context(), err)
+			writeDBError(w, r, err)
+			return
+		}
+		if !chain.add(entry) {
+			break
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+
+	result := chain.result
+	if !result.Valid {
+		slog.WarnContext(r.Context(), "Audit log chain broken", "seq", result.BrokenAt, "reason", result.Reason)
+	}
+	labelOutcome(r, "verified")
+	json.NewEncoder(w).Encode(result)
+}
+
+// auditChain checks the entries of the audit log, added in seq order.
+type auditChain struct {
+	result   AuditVerification
+	prevHash string
+}
+
+// add checks entry against the entries added before it. It returns false,
+// leaving the reason in the result, once the chain is broken.
+func (c *auditChain) add(entry bson.M) bool {
+	c.result.Entries++
+	seq, _ := entry["seq"].(int64)
+	hash, _ := entry["hash"].(string)
+	storedPrev, _ := entry["prev_hash"].(string)
+	computed, err := auditHash(entry)
+	switch {
+	case err != nil:
+		c.result.Reason = "entry cannot be hashed: " + err.Error()
+	case seq != c.result.Entries:
+		c.result.Reason = fmt.Sprintf("expected seq %d; entries are missing", c.result.Entries)
+	case storedPrev != c.prevHash:
+		c.result.Reason = "prev_hash does not match the entry before"
+	case hash != computed:
+		c.result.Reason = "hash does not match the entry's content"
+	}
+	if c.result.Reason != "" {
+		c.result.Valid, c.result.BrokenAt = false, seq
+		return false
+	}
+	c.prevHash = hash
+	return true
+}
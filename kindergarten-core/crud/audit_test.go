@@ -0,0 +1,340 @@
+package crud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"kindergarten-core/logging"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var auditTime = time.Date(2024, 5, 1, 9, 30, 0, 0, time.UTC)
+
+func auditEventFor(key, action string) auditEvent {
+	return auditEvent{
+		entity: "student", key: key, action: action, actor: "ann", requestID: "req-" + key,
+		before: nil, after: bson.M{"roll": key, "name": "Student " + key},
+	}
+}
+
+// fakeAuditStore keeps entries as Mongo would return them and, like the
+// unique seq index, refuses a seq that is taken.
+type fakeAuditStore struct {
+	entries []bson.M
+	// interloper, when set, is called before each entry goes in, to let
+	// another writer in
+	interloper func(s *fakeAuditStore)
+	inserts    int
+}
+
+func (s *fakeAuditStore) last(context.Context) (int64, string, error) {
+	if len(s.entries) == 0 {
+		return 0, "", nil
+	}
+	last := s.entries[len(s.entries)-1]
+	return last["seq"].(int64), last["hash"].(string), nil
+}
+
+func (s *fakeAuditStore) insert(_ context.Context, entries []bson.M) (int, bool, error) {
+	s.inserts++
+	for i, entry := range entries {
+		if s.interloper != nil {
+			s.interloper(s)
+		}
+		for _, stored := range s.entries {
+			if stored["seq"] == entry["seq"] {
+				return i, true, nil
+			}
+		}
+		doc, err := toDocument(entry)
+		if err != nil {
+			return i, false, err
+		}
+		doc["_id"] = primitive.NewObjectID()
+		s.entries = append(s.entries, doc)
+	}
+	return len(entries), false, nil
+}
+
+// chain appends n events to the store one by one, each a separate write.
+func (s *fakeAuditStore) chain(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := appendAuditEntries(context.Background(), s, []auditEvent{auditEventFor(string(rune('a'+i)), "create")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func verify(entries []bson.M) AuditVerification {
+	chain := auditChain{result: AuditVerification{Valid: true}}
+	for _, entry := range entries {
+		if !chain.add(entry) {
+			break
+		}
+	}
+	return chain.result
+}
+
+func TestAuditHash(t *testing.T) {
+	entry, err := newAuditEntry(1, "", auditTime, auditEventFor("7", "create"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := entry["hash"].(string)
+	// a change of format breaks every stored chain, so it must be deliberate
+	const golden = "0b2a2bd5428dfddb6d873e435a7646b7400eb7151fd6839ceee8b8da991c242a"
+	if hash != golden {
+		t.Errorf("hash = %s, want %s", hash, golden)
+	}
+
+	stored, err := toDocument(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored["_id"] = primitive.NewObjectID()
+	tests := []struct {
+		name   string
+		change func(e bson.M)
+		same   bool
+	}{
+		{"as read back from Mongo", func(bson.M) {}, true},
+		{"without _id and hash", func(e bson.M) { delete(e, "_id"); delete(e, "hash") }, true},
+		{"other _id", func(e bson.M) { e["_id"] = primitive.NewObjectID() }, true},
+		{"other actor", func(e bson.M) { e["actor"] = "mallory" }, false},
+		{"other seq", func(e bson.M) { e["seq"] = int64(2) }, false},
+		{"other prev_hash", func(e bson.M) { e["prev_hash"] = "00" }, false},
+		{"other time", func(e bson.M) { e["timestamp"] = primitive.NewDateTimeFromTime(auditTime.Add(time.Millisecond)) }, false},
+		{"nested change", func(e bson.M) { e["after"].(bson.M)["name"] = "Someone else" }, false},
+		{"field removed", func(e bson.M) { delete(e, "request_id") }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := toDocument(stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.change(e)
+			got, err := auditHash(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (got == hash) != tt.same {
+				t.Errorf("hash %s, entry's %s, want same = %v", got, hash, tt.same)
+			}
+		})
+	}
+}
+
+func TestAppendAuditEntries(t *testing.T) {
+	store := &fakeAuditStore{}
+	store.chain(t, 2)
+	events := []auditEvent{auditEventFor("x", "import"), auditEventFor("y", "import"), auditEventFor("z", "import")}
+	if err := appendAuditEntries(context.Background(), store, events); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.entries) != 5 || store.entries[4]["entity_key"] != "z" || store.entries[4]["request_id"] != "req-z" {
+		t.Fatalf("entries = %v, want five ending with the import of z", store.entries)
+	}
+	if result := verify(store.entries); !result.Valid || result.Entries != 5 {
+		t.Errorf("verification = %+v, want a valid chain of 5", result)
+	}
+}
+
+// appendOther appends an entry of another writer to s.
+func appendOther(s *fakeAuditStore, key string) {
+	seq, hash, _ := s.last(context.Background())
+	theirs, _ := newAuditEntry(seq+1, hash, auditTime, auditEventFor(key, "delete"))
+	theirs, _ = toDocument(theirs)
+	s.entries = append(s.entries, theirs)
+}
+
+func TestAppendAuditEntriesAfterCollision(t *testing.T) {
+	store := &fakeAuditStore{}
+	store.chain(t, 1)
+	store.inserts = 0
+
+	// another writer appends its entry once the first of the batch is in
+	entered := 0
+	store.interloper = func(s *fakeAuditStore) {
+		if entered++; entered == 2 {
+			appendOther(s, "other")
+		}
+	}
+	events := []auditEvent{auditEventFor("b", "create"), auditEventFor("c", "create")}
+	if err := appendAuditEntries(context.Background(), store, events); err != nil {
+		t.Fatal(err)
+	}
+
+	if store.inserts != 2 {
+		t.Errorf("%d inserts, want a retry after the collision", store.inserts)
+	}
+	var keys []string
+	for _, e := range store.entries {
+		keys = append(keys, e["entity_key"].(string))
+	}
+	if want := []string{"a", "b", "other", "c"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("entries for %v, want %v", keys, want)
+	}
+	if result := verify(store.entries); !result.Valid {
+		t.Errorf("verification = %+v, want the rest chained onto the other writer's entry", result)
+	}
+}
+
+func TestAppendAuditEntriesGivesUp(t *testing.T) {
+	store := &fakeAuditStore{}
+	// someone else always gets there first
+	store.interloper = func(s *fakeAuditStore) { appendOther(s, "other") }
+	err := appendAuditEntries(context.Background(), store, []auditEvent{auditEventFor("a", "create")})
+	if err == nil || store.inserts != auditInsertRetries {
+		t.Errorf("error = %v after %d inserts, want to give up after %d", err, store.inserts, auditInsertRetries)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	store.inserts = 0
+	if err := appendAuditEntries(ctx, store, []auditEvent{auditEventFor("a", "create")}); !errors.Is(err, context.Canceled) || store.inserts != 1 {
+		t.Errorf("error = %v after %d inserts, want to stop waiting once the request is gone", err, store.inserts)
+	}
+}
+
+func TestVerifyAuditChain(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(entries []bson.M) []bson.M
+		brokenAt int64
+		reason   string
+	}{
+		{
+			name:   "intact",
+			tamper: func(e []bson.M) []bson.M { return e },
+		},
+		{
+			name:     "changed entry",
+			tamper:   func(e []bson.M) []bson.M { e[2]["actor"] = "mallory"; return e },
+			brokenAt: 3,
+			reason:   "hash does not match the entry's content",
+		},
+		{
+			name: "changed entry with its hash recomputed",
+			tamper: func(e []bson.M) []bson.M {
+				e[2]["actor"] = "mallory"
+				e[2]["hash"], _ = auditHash(e[2])
+				return e
+			},
+			brokenAt: 4,
+			reason:   "prev_hash does not match the entry before",
+		},
+		{
+			name:     "deleted entry",
+			tamper:   func(e []bson.M) []bson.M { return append(e[:1], e[2:]...) },
+			brokenAt: 3,
+			reason:   "expected seq 2; entries are missing",
+		},
+		{
+			name: "reordered seq",
+			tamper: func(e []bson.M) []bson.M {
+				e[1]["seq"], e[2]["seq"] = e[2]["seq"], e[1]["seq"]
+				e[1], e[2] = e[2], e[1]
+				return e
+			},
+			brokenAt: 2,
+			reason:   "prev_hash does not match the entry before",
+		},
+		{
+			name: "renumbered after a deletion",
+			tamper: func(e []bson.M) []bson.M {
+				e = append(e[:1], e[2:]...)
+				for i := 1; i < len(e); i++ {
+					e[i]["seq"] = int64(i + 1)
+				}
+				return e
+			},
+			brokenAt: 2,
+			reason:   "prev_hash does not match the entry before",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeAuditStore{}
+			store.chain(t, 5)
+			result := verify(tt.tamper(store.entries))
+			if result.Valid != (tt.reason == "") || result.BrokenAt != tt.brokenAt || result.Reason != tt.reason {
+				t.Errorf("verification = %+v, want broken at %d: %q", result, tt.brokenAt, tt.reason)
+			}
+		})
+	}
+}
+
+func TestAuditFilter(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 5, 2, 12, 0, 0, 0, time.FixedZone("", 6*3600))
+	tests := []struct {
+		query   string
+		want    bson.M
+		invalid string
+	}{
+		{query: "", want: bson.M{}},
+		{
+			query: "entity=student&key=7&actor=ann&action=delete&limit=10",
+			want:  bson.M{"entity": "student", "entity_key": "7", "actor": "ann", "action": "delete"},
+		},
+		{query: "from=2024-05-01T00:00:00Z", want: bson.M{"timestamp": bson.M{"$gte": from}}},
+		{
+			query: "from=2024-05-01T00:00:00Z&to=2024-05-02T12:00:00%2B06:00",
+			want:  bson.M{"timestamp": bson.M{"$gte": from, "$lte": to}},
+		},
+		{query: "to=yesterday", invalid: "to"},
+		{query: "from=2024-05-01", invalid: "from"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			params, _ := url.ParseQuery(tt.query)
+			filter, invalid := auditFilter(params)
+			if tt.invalid != "" {
+				if invalid == nil || invalid.Field != tt.invalid || invalid.Code != "format" {
+					t.Errorf("invalid = %+v, want %s refused", invalid, tt.invalid)
+				}
+				return
+			}
+			if invalid != nil || !reflect.DeepEqual(filter, tt.want) {
+				t.Errorf("filter = %v, %+v, want %v", filter, invalid, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditRequestID(t *testing.T) {
+	var event auditEvent
+	handler := logging.Middleware(func(w http.ResponseWriter, r *http.Request) {
+		event = newAuditEvent(r, "student", "7", "delete", nil, nil)
+		writeProblem(w, r, http.StatusInternalServerError, codeAuditFailed, "audit failed")
+	}, "/delete-student")
+
+	for _, sent := range []string{"", "from-the-caller"} {
+		r := httptest.NewRequest(http.MethodDelete, "/delete-student?roll=7", nil)
+		if sent != "" {
+			r.Header.Set(logging.RequestIDHeader, sent)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+
+		var problem Problem
+		json.NewDecoder(w.Body).Decode(&problem)
+		id := w.Header().Get(logging.RequestIDHeader)
+		if id == "" || (sent != "" && id != sent) || event.requestID != id || problem.RequestID != id {
+			t.Errorf("sent %q: response header %q, audit %q, problem %q, want one ID", sent, id, event.requestID, problem.RequestID)
+		}
+		if r.Header.Get(logging.RequestIDHeader) != sent {
+			t.Error("request headers changed")
+		}
+	}
+}
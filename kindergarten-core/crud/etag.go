@@ -0,0 +1,54 @@
+package crud
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// setETag exposes a record's version as a strong ETag.
+func setETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", `"`+strconv.FormatInt(version, 10)+`"`)
+}
+
+// applyIfMatch adds the versions listed in the If-Match header to filter so the
+// precondition is checked by Mongo in the same operation as the write. It
+// reports whether a precondition was applied; a missing header or "*" applies none.
+func applyIfMatch(r *http.Request, filter bson.M) bool {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return false
+	}
+
+	versions := bson.A{}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		// If-Match uses strong comparison, so weak tags never match
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, version)
+		// records written before versioning have no version field yet
+		if version == 0 {
+			versions = append(versions, nil)
+		}
+	}
+
+	filter["version"] = bson.M{"$in": versions}
+	return true
+}
+
+// recordExists tells a failed precondition (412) apart from a missing record (404).
+func recordExists(ctx context.Context, collection *mongo.Collection, filter bson.M) bool {
+	count, err := collection.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	return err == nil && count > 0
+}
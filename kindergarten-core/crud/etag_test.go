@@ -0,0 +1,46 @@
+package crud
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestApplyIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		applied bool
+		want    bson.A
+	}{
+		{name: "no header"},
+		{name: "any version", ifMatch: "*"},
+		{name: "one version", ifMatch: `"3"`, applied: true, want: bson.A{int64(3)}},
+		{name: "several versions", ifMatch: ` "3", "4" `, applied: true, want: bson.A{int64(3), int64(4)}},
+		{name: "version 0 matches unversioned records", ifMatch: `"0"`, applied: true, want: bson.A{int64(0), nil}},
+		{name: "weak tags never match", ifMatch: `W/"3"`, applied: true, want: bson.A{}},
+		{name: "malformed tags never match", ifMatch: `3, "x", "`, applied: true, want: bson.A{}},
+		{name: "good tags among bad ones", ifMatch: `W/"2", "5"`, applied: true, want: bson.A{int64(5)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/students/7", nil)
+			if tt.ifMatch != "" {
+				r.Header.Set("If-Match", tt.ifMatch)
+			}
+			filter := bson.M{"roll": "7"}
+			if applied := applyIfMatch(r, filter); applied != tt.applied {
+				t.Errorf("applied = %v, want %v", applied, tt.applied)
+			}
+			want := bson.M{"roll": "7"}
+			if tt.applied {
+				want["version"] = bson.M{"$in": tt.want}
+			}
+			if !reflect.DeepEqual(filter, want) {
+				t.Errorf("filter = %v, want %v", filter, want)
+			}
+		})
+	}
+}
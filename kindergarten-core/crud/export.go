@@ -0,0 +1,192 @@
+package crud
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"kindergarten-core/tracing"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const exportBatchSize = 500
+
+// exportContentTypes lists the formats an export can be written in.
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"xlsx":   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"ndjson": "application/x-ndjson",
+}
+
+// exportWriter writes records one at a time so exports never sit in memory.
+type exportWriter interface {
+	WriteRecord(values []string) error
+	Close() error
+}
+
+// newExportWriter starts an export; CSV and XLSX begin with a header row
+// naming the columns, so a CSV export can be fed back into the import.
+func newExportWriter(format string, w io.Writer, title string, columns []string) (exportWriter, error) {
+	var out exportWriter
+	switch format {
+	case "ndjson":
+		return &ndjsonWriter{w: w, columns: columns}, nil
+	case "xlsx":
+		x, err := newXLSXWriter(w, title)
+		if err != nil {
+			return nil, err
+		}
+		out = x
+	default:
+		out = &csvWriter{csv.NewWriter(w)}
+	}
+	return out, out.WriteRecord(columns)
+}
+
+// formulaPrefixes are the characters that make a spreadsheet read a cell as a
+// formula, with the tab and carriage return some of them skip first.
+const formulaPrefixes = "=+-@\t\r"
+
+// escapeFormula puts an apostrophe in front of a value a spreadsheet would run
+// as a formula, so it opens as text instead. readCSV takes it off again, so
+// values that already start with an apostrophe get one too.
+func escapeFormula(value string) string {
+	if value != "" && strings.IndexByte(formulaPrefixes+"'", value[0]) >= 0 {
+		return "'" + value
+	}
+	return value
+}
+
+// unescapeFormula undoes escapeFormula.
+func unescapeFormula(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.IndexByte(formulaPrefixes+"'", value[1]) >= 0 {
+		return value[1:]
+	}
+	return value
+}
+
+// csvWriter escapes every value that could be taken for a formula.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) WriteRecord(values []string) error {
+	escaped := make([]string, len(values))
+	for i, v := range values {
+		escaped[i] = escapeFormula(v)
+	}
+	return c.w.Write(escaped)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonWriter writes every record as a JSON object on its own line, keeping
+// the members in column order.
+type ndjsonWriter struct {
+	w       io.Writer
+	columns []string
+}
+
+func (n *ndjsonWriter) WriteRecord(values []string) error {
+	line := []byte{'{'}
+	for i, column := range n.columns {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		key, _ := json.Marshal(column)
+		value, _ := json.Marshal(values[i])
+		line = append(append(append(line, key...), ':'), value...)
+	}
+	_, err := n.w.Write(append(line, '}', '\n'))
+	return err
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+// exportValues returns the string fields of model in column order.
+func exportValues(model interface{}, columns []string) []string {
+	values := make([]string, len(columns))
+	for _, f := range modelFields(model) {
+		for i, column := range columns {
+			if column == f.name {
+				values[i] = f.value.String()
+			}
+		}
+	}
+	return values
+}
+
+// Export streams the records as CSV, XLSX or NDJSON, chosen with the format
+// parameter (CSV by default). It takes the filter and sort parameters of List,
+// but always exports every matching record.
+func (res *Resource[T, P]) Export(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		writeProblem(w, r, http.StatusBadRequest, codeValidationFailed, "format must be csv, xlsx or ndjson", FieldError{Field: "format", Code: "unsupported", Message: "must be csv, xlsx or ndjson"})
+		return
+	}
+
+	query, err := parseListQuery(r, res.fields, res.Key)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	notDeleted(query.Filter)
+
+	span, ctx := tracing.StartSpan(r.Context(), "Export"+res.plural()+"FromDB", "app")
+	defer span.End()
+
+	collection := res.collection(ctx)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, query.Filter, options.Find().SetSort(query.Sort).SetBatchSize(exportBatchSize))
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	filename := fmt.Sprintf("%s-%s.%s", res.Collection, time.Now().Format("2006-01-02"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+
+	labelOutcome(r, "exported")
+	out, err := newExportWriter(format, w, res.plural(), res.fields)
+	for err == nil && cursor.Next(ctx) {
+		var record T
+		if err = cursor.Decode(&record); err == nil {
+			err = out.WriteRecord(exportValues(P(&record), res.fields))
+		}
+	}
+	if err == nil {
+		err = cursor.Err()
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		slog.ErrorContext(r.Context(), "Export failed", "collection", res.Collection, "error", err)
+		// the status line is gone already; break the connection so the client
+		// sees a failed download rather than a silently truncated file
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,190 @@
+package crud
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEscapeFormula(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"", ""},
+		{"Ann", "Ann"},
+		{"=1+1", "'=1+1"},
+		{"+880 17", "'+880 17"},
+		{"-7", "'-7"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"'", "''"},
+		{"'Ann", "''Ann"},
+		{"'=1", "''=1"},
+		{"Ann=1", "Ann=1"},
+	}
+	for _, tt := range tests {
+		if got := escapeFormula(tt.value); got != tt.want {
+			t.Errorf("escapeFormula(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+		if got := unescapeFormula(tt.want); got != tt.value {
+			t.Errorf("unescapeFormula(%q) = %q, want %q", tt.want, got, tt.value)
+		}
+	}
+}
+
+func TestUnescapeFormulaLeavesOtherApostrophes(t *testing.T) {
+	for _, value := range []string{"'", "'Ann", "O'Neil", "Ann'"} {
+		if got := unescapeFormula(value); got != value {
+			t.Errorf("unescapeFormula(%q) = %q, want it unchanged", value, got)
+		}
+	}
+}
+
+func TestCSVRoundTrip(t *testing.T) {
+	columns := []string{"name", "roll", "address"}
+	rows := [][]string{
+		{"'Ann", "1", "=HYPERLINK(\"http://x\")"},
+		{"''Bob", "2", "-12 Lake Road"},
+		{"O'Neil", "3", "'+880 17"},
+	}
+	var buf bytes.Buffer
+	out, err := newExportWriter("csv", &buf, "Students", columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := out.WriteRecord(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records[0], columns) {
+		t.Errorf("header = %q, want %q", records[0], columns)
+	}
+	for i, record := range records[1:] {
+		if strings.HasPrefix(record[2], "=") || strings.HasPrefix(record[2], "-") {
+			t.Errorf("row %d: %q is not escaped", i+1, record[2])
+		}
+		for j, v := range record {
+			if got := unescapeFormula(v); got != rows[i][j] {
+				t.Errorf("row %d column %d imports as %q, want %q", i+1, j, got, rows[i][j])
+			}
+		}
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	out, err := newExportWriter("csv", &buf, "Students", studentFields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := student{Name: "'Ann", Roll: "7", Address: "-12 Lake Road", Nickname: "'"}
+	if err := out.WriteRecord(exportValues(&want, studentFields)); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+
+	rows, result, err := newStudents().readCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].record != want {
+		t.Errorf("imported %+v (errors %+v), want %+v", rows, result.Errors, want)
+	}
+}
+
+func TestXLSXWriter(t *testing.T) {
+	var buf bytes.Buffer
+	out, err := newExportWriter("xlsx", &buf, "Students & <Staff>", []string{"name", "roll"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.WriteRecord([]string{"=1+1", "a<b>&\x01c"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := map[string]string{}
+	var names []string
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, f.Name)
+		parts[f.Name] = string(body)
+		if err := xml.Unmarshal(body, new(struct{})); err != nil {
+			t.Errorf("%s is not well-formed XML: %v", f.Name, err)
+		}
+	}
+	wantNames := []string{"[Content_Types].xml", "_rels/.rels", "xl/_rels/workbook.xml.rels", "xl/workbook.xml", "xl/worksheets/sheet1.xml"}
+	if !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("parts = %q, want %q", names, wantNames)
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `<sheet name="Students &amp; &lt;Staff&gt;"`) {
+		t.Errorf("workbook = %s, want the sheet name escaped", parts["xl/workbook.xml"])
+	}
+
+	var sheet struct {
+		Rows []struct {
+			R     int `xml:"r,attr"`
+			Cells []struct {
+				R    string `xml:"r,attr"`
+				Type string `xml:"t,attr"`
+				Text string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal([]byte(parts["xl/worksheets/sheet1.xml"]), &sheet); err != nil {
+		t.Fatal(err)
+	}
+	var got [][]string
+	for _, row := range sheet.Rows {
+		var cells []string
+		for _, c := range row.Cells {
+			if c.Type != "inlineStr" {
+				t.Errorf("cell %s has type %q, want inlineStr", c.R, c.Type)
+			}
+			cells = append(cells, c.R+"="+c.Text)
+		}
+		got = append(got, cells)
+	}
+	// inline strings are never evaluated, so the formula stays as it is
+	want := [][]string{{"A1=name", "B1=roll"}, {"A2==1+1", "B2=a<b>&\ufffdc"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cells = %q, want %q", got, want)
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if got := xlsxColumn(i); got != want {
+			t.Errorf("xlsxColumn(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
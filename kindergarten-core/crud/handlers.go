@@ -0,0 +1,436 @@
+package crud
+
+import (
+	"context"
+	"encoding/json"
+	"kindergarten-core/tracing"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// List returns a page of the records that are not in the trash.
+func (res *Resource[T, P]) List(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query, err := parseListQuery(r, res.fields, res.Key)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	notDeleted(query.Filter)
+
+	span, ctx := tracing.StartSpan(r.Context(), "Get"+res.plural()+"FromDB", "app")
+	defer span.End()
+
+	collection := res.collection(ctx)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	total, err := collection.CountDocuments(ctx, query.Filter)
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+
+	cursor, err := collection.Find(ctx, query.findFilter(), query.findOptions())
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var found []bson.Raw
+	if err = cursor.All(ctx, &found); err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+	found, next := query.page(found)
+	records := make([]T, len(found))
+	for i, doc := range found {
+		if err = bson.Unmarshal(doc, &records[i]); err != nil {
+			tracing.CaptureError(r.Context(), err)
+			writeDBError(w, r, err)
+			return
+		}
+	}
+
+	writePageHeaders(w, r, query, total, len(records), next)
+	json.NewEncoder(w).Encode(records)
+}
+
+// Add creates a record unless one with the same key exists, even in the trash.
+func (res *Resource[T, P]) Add(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var record T
+	invalid, err := decodeValid(r, P(&record))
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if len(invalid) > 0 {
+		writeProblem(w, r, http.StatusBadRequest, codeValidationFailed, res.Name+" failed validation", invalid...)
+		return
+	}
+	key := res.keyOf(&record)
+	tracing.SetLabel(r.Context(), res.Key, key)
+
+	span, ctx := tracing.StartSpan(r.Context(), "Add"+res.Name+"ToDB", "app")
+	defer span.End()
+
+	collection := res.collection(ctx)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if recordExists(ctx, collection, bson.M{res.Key: key}) {
+		writeProblem(w, r, http.StatusConflict, res.duplicateCode(), res.duplicateMessage())
+		return
+	}
+
+	meta := P(&record).meta()
+	meta.Version = 1
+	meta.DeletedAt = nil
+	_, err = collection.InsertOne(ctx, &record)
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		res.writeDBError(w, r, err)
+		return
+	}
+
+	if !recordAudit(ctx, w, r, res.entity, key, "create", nil, &record) {
+		return
+	}
+	labelOutcome(r, "created")
+
+	setETag(w, meta.Version)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(&record)
+}
+
+// Delete moves a record to the trash; StartTrashPurge removes it later.
+func (res *Resource[T, P]) Delete(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	key, ok := res.keyParam(w, r)
+	if !ok {
+		return
+	}
+
+	span, ctx := tracing.StartSpan(r.Context(), "Delete"+res.Name+"FromDB", "app")
+	defer span.End()
+
+	collection := res.collection(ctx)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := notDeleted(bson.M{res.Key: key})
+	checked := applyIfMatch(r, filter)
+
+	update := bson.M{"$set": bson.M{"deleted_at": time.Now().UTC()}, "$inc": bson.M{"version": 1}}
+	var before T
+	err := collection.FindOneAndUpdate(
+		ctx,
+		filter,
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		res.writeNoMatch(ctx, w, r, collection, key, checked)
+		return
+	}
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+
+	var after T
+	applyUpdate(&before, update, &after)
+	if !recordAudit(ctx, w, r, res.entity, key, "delete", &before, &after) {
+		return
+	}
+	labelOutcome(r, "deleted")
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": res.Name + " moved to trash"})
+}
+
+// Update replaces the fields of the record named by the key in the body.
+func (res *Resource[T, P]) Update(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var updated T
+	invalid, err := decodeValid(r, P(&updated))
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if len(invalid) > 0 {
+		writeProblem(w, r, http.StatusBadRequest, codeValidationFailed, res.Name+" failed validation", invalid...)
+		return
+	}
+	key := res.keyOf(&updated)
+	tracing.SetLabel(r.Context(), res.Key, key)
+
+	span, ctx := tracing.StartSpan(r.Context(), "Update"+res.Name+"InDB", "app")
+	defer span.End()
+
+	collection := res.collection(ctx)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := notDeleted(bson.M{res.Key: key})
+	checked := applyIfMatch(r, filter)
+
+	update := bson.M{"$set": &updated, "$inc": bson.M{"version": 1}}
+	var before T
+	err = collection.FindOneAndUpdate(
+		ctx,
+		filter,
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		res.writeNoMatch(ctx, w, r, collection, key, checked)
+		return
+	}
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+
+	applyUpdate(&before, update, &updated)
+	if !recordAudit(ctx, w, r, res.entity, key, "update", &before, &updated) {
+		return
+	}
+	labelOutcome(r, "updated")
+
+	setETag(w, P(&updated).meta().Version)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&updated)
+}
+
+// Get returns one record that is not in the trash.
+func (res *Resource[T, P]) Get(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	key, ok := res.keyParam(w, r)
+	if !ok {
+		return
+	}
+
+	span, ctx := tracing.StartSpan(r.Context(), "Get"+res.Name+"FromDB", "app")
+	defer span.End()
+
+	collection := res.collection(ctx)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var record T
+	err := collection.FindOne(ctx, notDeleted(bson.M{res.Key: key})).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		writeProblem(w, r, http.StatusNotFound, codeNotFound, res.Name+" not found")
+		return
+	}
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+
+	labelOutcome(r, "found")
+	setETag(w, P(&record).meta().Version)
+	json.NewEncoder(w).Encode(&record)
+}
+
+// Patch applies a JSON merge patch (RFC 7386) to a record, touching only the
+// fields present in the patch.
+func (res *Resource[T, P]) Patch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	key, ok := res.keyParam(w, r)
+	if !ok {
+		return
+	}
+
+	patch, invalid, err := readMergePatch(r, res.fields, P(new(T)))
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if len(invalid) > 0 {
+		writeProblem(w, r, http.StatusBadRequest, codeValidationFailed, res.Name+" failed validation", invalid...)
+		return
+	}
+	if value, ok := patch[res.Key]; ok && value != key {
+		writeProblem(w, r, http.StatusBadRequest, codeValidationFailed, res.Key+" cannot be changed", FieldError{Field: res.Key, Code: "immutable", Message: res.Key + " cannot be changed"})
+		return
+	}
+
+	span, ctx := tracing.StartSpan(r.Context(), "Patch"+res.Name+"InDB", "app")
+	defer span.End()
+
+	collection := res.collection(ctx)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := notDeleted(bson.M{res.Key: key})
+	checked := applyIfMatch(r, filter)
+
+	var result *mongo.SingleResult
+	update := mergePatchUpdate(patch)
+	if update != nil {
+		update["$inc"] = bson.M{"version": 1}
+		result = collection.FindOneAndUpdate(
+			ctx,
+			filter,
+			update,
+			options.FindOneAndUpdate().SetReturnDocument(options.Before),
+		)
+	} else {
+		result = collection.FindOne(ctx, filter)
+	}
+
+	var record T
+	err = result.Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		res.writeNoMatch(ctx, w, r, collection, key, checked)
+		return
+	}
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+
+	if update != nil {
+		before := record
+		applyUpdate(&before, update, &record)
+		if !recordAudit(ctx, w, r, res.entity, key, "patch", &before, &record) {
+			return
+		}
+	}
+	labelOutcome(r, "updated")
+
+	setETag(w, P(&record).meta().Version)
+	json.NewEncoder(w).Encode(&record)
+}
+
+// Trash lists the records in the trash. It takes the same pagination, sorting
+// and filter parameters as List.
+func (res *Resource[T, P]) Trash(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	query, err := parseListQuery(r, res.fields, res.Key)
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	query.Filter["deleted_at"] = bson.M{"$exists": true}
+
+	span, ctx := tracing.StartSpan(r.Context(), "GetDeleted"+res.plural()+"FromDB", "app")
+	defer span.End()
+
+	collection := res.collection(ctx)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	total, err := collection.CountDocuments(ctx, query.Filter)
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+
+	cursor, err := collection.Find(ctx, query.findFilter(), query.findOptions())
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var found []bson.Raw
+	if err = cursor.All(ctx, &found); err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+	found, next := query.page(found)
+	records := make([]T, len(found))
+	for i, doc := range found {
+		if err = bson.Unmarshal(doc, &records[i]); err != nil {
+			tracing.CaptureError(r.Context(), err)
+			writeDBError(w, r, err)
+			return
+		}
+	}
+
+	writePageHeaders(w, r, query, total, len(records), next)
+	json.NewEncoder(w).Encode(records)
+}
+
+// Restore takes a record back out of the trash.
+func (res *Resource[T, P]) Restore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	key, ok := res.keyParam(w, r)
+	if !ok {
+		return
+	}
+
+	span, ctx := tracing.StartSpan(r.Context(), "Restore"+res.Name+"InDB", "app")
+	defer span.End()
+
+	collection := res.collection(ctx)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	update := bson.M{"$unset": bson.M{"deleted_at": ""}, "$inc": bson.M{"version": 1}}
+	var before T
+	err := collection.FindOneAndUpdate(
+		ctx,
+		bson.M{res.Key: key, "deleted_at": bson.M{"$exists": true}},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.Before),
+	).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		writeProblem(w, r, http.StatusNotFound, codeNotFound, res.Name+" not found in trash")
+		return
+	}
+	if err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+
+	var record T
+	applyUpdate(&before, update, &record)
+	if !recordAudit(ctx, w, r, res.entity, key, "restore", &before, &record) {
+		return
+	}
+	labelOutcome(r, "restored")
+
+	setETag(w, P(&record).meta().Version)
+	json.NewEncoder(w).Encode(&record)
+}
+
+// writeNoMatch answers a conditional write that matched nothing: 412 when the
+// record exists but If-Match named another version, 404 otherwise.
+func (res *Resource[T, P]) writeNoMatch(ctx context.Context, w http.ResponseWriter, r *http.Request, collection *mongo.Collection, key string, checked bool) {
+	if checked && recordExists(ctx, collection, notDeleted(bson.M{res.Key: key})) {
+		writeProblem(w, r, http.StatusPreconditionFailed, codePreconditionFailed, res.Name+" was modified by someone else")
+		return
+	}
+	writeProblem(w, r, http.StatusNotFound, codeNotFound, res.Name+" not found")
+}
@@ -0,0 +1,328 @@
+package crud
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kindergarten-core/tracing"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxImportBytes  = 10 << 20
+	maxImportRows   = 10000
+	importBatchSize = 500
+)
+
+// ImportRowError lists what is wrong with one CSV row. Rows are numbered the
+// way a spreadsheet shows them, so the header is row 1.
+type ImportRowError struct {
+	Row    int          `json:"row"`
+	Key    string       `json:"key,omitempty"`
+	Errors []FieldError `json:"errors"`
+}
+
+// ImportResult is the response of an import or a dry run. Incomplete is set
+// when a database error stopped the import after some rows were inserted;
+// the rows it did not get to are listed in Errors. Unaudited counts inserted
+// rows whose audit log entries could not be written.
+type ImportResult struct {
+	DryRun     bool             `json:"dry_run"`
+	Rows       int              `json:"rows"`
+	Valid      int              `json:"valid"`
+	Inserted   int              `json:"inserted"`
+	Incomplete bool             `json:"incomplete,omitempty"`
+	Unaudited  int              `json:"unaudited,omitempty"`
+	Errors     []ImportRowError `json:"errors"`
+}
+
+type importedRecord[T any] struct {
+	row    int
+	key    string
+	record T
+}
+
+// Import creates records from a CSV body whose header row names the model
+// fields. Every row is validated and checked against the same duplicate key
+// rule as Add. Valid rows are inserted even if other rows are rejected; with
+// dry_run=true nothing is written and the result previews what the import
+// would do. Rows are inserted in batches, so a database error once some of
+// them are in does not fail the request: the result counts the inserted rows
+// and lists the others, which can be imported again.
+func (res *Resource[T, P]) Import(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			writeProblem(w, r, http.StatusBadRequest, codeValidationFailed, "dry_run must be true or false", FieldError{Field: "dry_run", Code: "type", Message: "must be a bool"})
+			return
+		}
+	}
+
+	rows, result, err := res.readCSV(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, codeTooLarge, fmt.Sprintf("CSV file is larger than %d MiB", maxImportBytes>>20))
+		return
+	}
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	result.DryRun = dryRun
+
+	span, ctx := tracing.StartSpan(r.Context(), "Import"+res.plural()+"ToDB", "app")
+	defer span.End()
+
+	collection := res.collection(ctx)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	if err := res.importRows(ctx, r, collection, rows, result); err != nil {
+		tracing.CaptureError(r.Context(), err)
+		writeDBError(w, r, err)
+		return
+	}
+
+	labelOutcome(r, "imported")
+	if !dryRun {
+		tracing.Count(r.Context(), tracing.DuplicateRejections, res.duplicateRows(result))
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// importCollection is the part of a collection an import uses.
+type importCollection interface {
+	Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error)
+}
+
+// importRows drops the rows whose key is taken and, unless result is a dry
+// run, inserts the others. It only fails when nothing was inserted; a
+// database error after that stops the import and lists the rows left out.
+func (res *Resource[T, P]) importRows(ctx context.Context, r *http.Request, collection importCollection, rows []importedRecord[T], result *ImportResult) error {
+	rows, err := res.dropExisting(ctx, collection, rows, result)
+	if err != nil {
+		return err
+	}
+	result.Valid = len(rows)
+	if result.DryRun {
+		return nil
+	}
+
+	for start := 0; start < len(rows); start += importBatchSize {
+		batch := rows[start:min(start+importBatchSize, len(rows))]
+		if err := res.insertBatch(ctx, r, collection, batch, result); err != nil {
+			if result.Inserted == 0 {
+				return err
+			}
+			tracing.CaptureError(r.Context(), err)
+			slog.ErrorContext(r.Context(), "Import stopped by a database error", "inserted", result.Inserted, "left", len(rows)-start, "error", err)
+			res.notImported(result, rows[start:], dbErrorCode(err))
+			break
+		}
+	}
+	return nil
+}
+
+// readCSV parses and validates the CSV file. Rows with errors are reported in
+// the result and left out of the returned rows; the error is only set when the
+// file as a whole cannot be used.
+func (res *Resource[T, P]) readCSV(body io.Reader) ([]importedRecord[T], *ImportResult, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("CSV file is empty")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	columns := make([]string, len(header))
+	for i, name := range header {
+		// spreadsheets often save a byte order mark in front of the header
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !contains(res.fields, name) {
+			return nil, nil, fmt.Errorf("unknown column %q; columns must be among %s", name, strings.Join(res.fields, ", "))
+		}
+		if contains(columns[:i], name) {
+			return nil, nil, fmt.Errorf("column %q appears more than once", name)
+		}
+		columns[i] = name
+	}
+
+	result := &ImportResult{Errors: []ImportRowError{}}
+	var rows []importedRecord[T]
+	seen := map[string]int{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		result.Rows++
+		if result.Rows > maxImportRows {
+			return nil, nil, fmt.Errorf("CSV file has more than %d rows", maxImportRows)
+		}
+		row := result.Rows + 1
+
+		if len(record) != len(columns) {
+			result.Errors = append(result.Errors, ImportRowError{Row: row, Errors: []FieldError{{
+				Code:    "column_count",
+				Message: fmt.Sprintf("row has %d columns, the header has %d", len(record), len(columns)),
+			}}})
+			continue
+		}
+
+		var model T
+		for _, f := range modelFields(P(&model)) {
+			for i, name := range columns {
+				if name == f.name {
+					f.value.SetString(unescapeFormula(record[i]))
+				}
+			}
+		}
+		invalid := validateFields(P(&model), res.fields)
+		key := res.keyOf(&model)
+		if first, ok := seen[key]; ok && len(invalid) == 0 {
+			invalid = append(invalid, FieldError{Field: res.Key, Code: res.duplicateCode(), Message: fmt.Sprintf("%s is already used in row %d", res.Key, first)})
+		}
+		if len(invalid) > 0 {
+			result.Errors = append(result.Errors, ImportRowError{Row: row, Key: key, Errors: invalid})
+			continue
+		}
+		seen[key] = row
+		rows = append(rows, importedRecord[T]{row: row, key: key, record: model})
+	}
+	return rows, result, nil
+}
+
+// dropExisting reports rows whose key is already taken, including by records
+// in the trash, and returns the rest.
+func (res *Resource[T, P]) dropExisting(ctx context.Context, collection importCollection, rows []importedRecord[T], result *ImportResult) ([]importedRecord[T], error) {
+	if len(rows) == 0 {
+		return rows, nil
+	}
+	keys := make([]string, len(rows))
+	for i, row := range rows {
+		keys[i] = row.key
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{res.Key: bson.M{"$in": keys}}, options.Find().SetProjection(bson.M{res.Key: 1}))
+	if err != nil {
+		return nil, err
+	}
+	var existing []T
+	if err := cursor.All(ctx, &existing); err != nil {
+		return nil, err
+	}
+	taken := map[string]bool{}
+	for i := range existing {
+		taken[res.keyOf(&existing[i])] = true
+	}
+
+	kept := rows[:0]
+	for _, row := range rows {
+		if taken[row.key] {
+			result.Errors = append(result.Errors, res.duplicateRow(row))
+			continue
+		}
+		kept = append(kept, row)
+	}
+	return kept, nil
+}
+
+// insertBatch bulk inserts one batch. Rows that still collide with a record
+// added since the duplicate check are reported instead of failing the whole
+// import.
+func (res *Resource[T, P]) insertBatch(ctx context.Context, r *http.Request, collection importCollection, batch []importedRecord[T], result *ImportResult) error {
+	writes := make([]mongo.WriteModel, len(batch))
+	for i := range batch {
+		P(&batch[i].record).meta().Version = 1
+		writes[i] = mongo.NewInsertOneModel().SetDocument(&batch[i].record)
+	}
+
+	failed := map[int]bool{}
+	_, err := collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if errors.As(err, &bulkErr) && bulkErr.WriteConcernError == nil {
+		// the write errors name the rows that failed; the others are in
+		for _, writeErr := range bulkErr.WriteErrors {
+			failed[writeErr.Index] = true
+			result.Valid--
+			if mongo.IsDuplicateKeyError(writeErr) {
+				result.Errors = append(result.Errors, res.duplicateRow(batch[writeErr.Index]))
+				continue
+			}
+			slog.ErrorContext(ctx, "Import row failed", "row", batch[writeErr.Index].row, "error", writeErr)
+			result.Errors = append(result.Errors, notImportedRow(batch[writeErr.Index], codeInternal))
+		}
+	} else if err != nil {
+		return err
+	}
+
+	var events []auditEvent
+	for i := range batch {
+		if !failed[i] {
+			result.Inserted++
+			events = append(events, newAuditEvent(r, res.entity, batch[i].key, "import", nil, &batch[i].record))
+		}
+	}
+	if len(events) > 0 && writeAudit(ctx, events...) != nil {
+		result.Unaudited += len(events)
+	}
+	return nil
+}
+
+// notImported reports rows that were left out because the import stopped on
+// a database error. Importing them again is safe: rows that did get in before
+// the error are rejected as duplicates.
+func (res *Resource[T, P]) notImported(result *ImportResult, rows []importedRecord[T], code string) {
+	result.Incomplete = true
+	result.Valid -= len(rows)
+	for _, row := range rows {
+		result.Errors = append(result.Errors, notImportedRow(row, code))
+	}
+}
+
+func notImportedRow[T any](row importedRecord[T], code string) ImportRowError {
+	return ImportRowError{Row: row.row, Key: row.key, Errors: []FieldError{{
+		Code: code, Message: "not imported because of a database error; import the row again",
+	}}}
+}
+
+// duplicateRows counts the rows of result rejected for a key that was taken.
+func (res *Resource[T, P]) duplicateRows(result *ImportResult) int {
+	n := 0
+	for _, row := range result.Errors {
+		for _, e := range row.Errors {
+			if e.Code == res.duplicateCode() {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func (res *Resource[T, P]) duplicateRow(row importedRecord[T]) ImportRowError {
+	return ImportRowError{Row: row.row, Key: row.key, Errors: []FieldError{{
+		Field: res.Key, Code: res.duplicateCode(), Message: res.duplicateMessage(),
+	}}}
+}
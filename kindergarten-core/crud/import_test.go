@@ -0,0 +1,203 @@
+package crud
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func newStudents() *Resource[student, *student] {
+	return NewResource[student](Config{Name: "Student", Collection: "students", Key: "roll", KeyLabel: "roll number"})
+}
+
+// fakeImportCollection finds the records in existing and answers bulk writes
+// with writeErr.
+type fakeImportCollection struct {
+	existing []student
+	writeErr error
+	written  []mongo.WriteModel
+}
+
+func (c *fakeImportCollection) Find(_ context.Context, filter interface{}, _ ...*options.FindOptions) (*mongo.Cursor, error) {
+	keys := filter.(bson.M)["roll"].(bson.M)["$in"].([]string)
+	var docs []interface{}
+	for _, s := range c.existing {
+		if contains(keys, s.Roll) {
+			docs = append(docs, bson.M{"roll": s.Roll})
+		}
+	}
+	return mongo.NewCursorFromDocuments(docs, nil, nil)
+}
+
+func (c *fakeImportCollection) BulkWrite(_ context.Context, models []mongo.WriteModel, _ ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	c.written = append(c.written, models...)
+	return &mongo.BulkWriteResult{}, c.writeErr
+}
+
+func importRow(row int, roll, name string) importedRecord[student] {
+	return importedRecord[student]{row: row, key: roll, record: student{Name: name, Roll: roll, Address: "Lake Road"}}
+}
+
+func TestReadCSV(t *testing.T) {
+	body := "\ufeffName, ROLL ,address\n" +
+		"Ann,1,Lake Road\n" +
+		"Bob,2\n" +
+		",3,Hill Street\n" +
+		"Cat,1,River Road\n" +
+		"Dan,4,\"Hill Street, 3\"\n"
+	rows, result, err := newStudents().readCSV(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []importedRecord[student]{
+		{row: 2, key: "1", record: student{Name: "Ann", Roll: "1", Address: "Lake Road"}},
+		{row: 6, key: "4", record: student{Name: "Dan", Roll: "4", Address: "Hill Street, 3"}},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %+v, want %+v", rows, want)
+	}
+	wantResult := &ImportResult{Rows: 5, Errors: []ImportRowError{
+		{Row: 3, Errors: []FieldError{{Code: "column_count", Message: "row has 2 columns, the header has 3"}}},
+		{Row: 4, Key: "3", Errors: []FieldError{{Field: "name", Code: "required", Message: "name is required"}}},
+		{Row: 5, Key: "1", Errors: []FieldError{{Field: "roll", Code: "duplicate_roll", Message: "roll is already used in row 2"}}},
+	}}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result = %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"empty", "", "CSV file is empty"},
+		{"unknown column", "name,grade\n", `unknown column "grade"; columns must be among name, roll, address, nickname`},
+		{"column twice", "roll,Roll\n", `column "roll" appears more than once`},
+		{"bad quote in header", "na\"me\n", "invalid CSV: "},
+		{"bad quote in row", "roll\n\"7\n", "invalid CSV: "},
+		{"too many rows", "roll\n" + strings.Repeat("7\n", maxImportRows+1), "CSV file has more than 10000 rows"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := newStudents().readCSV(strings.NewReader(tt.body))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportTooLarge(t *testing.T) {
+	body := "name\n" + strings.Repeat("a", maxImportBytes)
+	w := httptest.NewRecorder()
+	newStudents().Import(w, httptest.NewRequest("POST", "/students/import", strings.NewReader(body)))
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), `"code":"payload_too_large"`) {
+		t.Errorf("response %d %s, want 413 payload_too_large", w.Code, w.Body)
+	}
+}
+
+func TestDropExisting(t *testing.T) {
+	res := newStudents()
+	// the trashed record still holds its roll number
+	collection := &fakeImportCollection{existing: []student{{Roll: "2"}, {Roll: "4", Meta: Meta{DeletedAt: &auditTime}}, {Roll: "9"}}}
+	rows := []importedRecord[student]{importRow(2, "1", "Ann"), importRow(3, "2", "Bob"), importRow(4, "3", "Cat"), importRow(5, "4", "Dan")}
+	result := &ImportResult{Errors: []ImportRowError{}}
+
+	kept, err := res.dropExisting(context.Background(), collection, rows, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []importedRecord[student]{importRow(2, "1", "Ann"), importRow(4, "3", "Cat")}; !reflect.DeepEqual(kept, want) {
+		t.Errorf("kept = %+v, want %+v", kept, want)
+	}
+	taken := []FieldError{{Field: "roll", Code: "duplicate_roll", Message: "Student with this roll number already exists"}}
+	want := []ImportRowError{{Row: 3, Key: "2", Errors: taken}, {Row: 5, Key: "4", Errors: taken}}
+	if !reflect.DeepEqual(result.Errors, want) {
+		t.Errorf("errors = %+v, want %+v", result.Errors, want)
+	}
+}
+
+func TestImportRows(t *testing.T) {
+	duplicate := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Index: 1, Code: 11000}}}}
+	tests := []struct {
+		name     string
+		dryRun   bool
+		writeErr error
+		want     ImportResult
+		written  int
+		audited  int
+	}{
+		{
+			name:   "dry run writes nothing",
+			dryRun: true,
+			want:   ImportResult{DryRun: true, Valid: 3, Errors: []ImportRowError{{Row: 3, Key: "2"}}},
+		},
+		{
+			name:    "commit",
+			want:    ImportResult{Valid: 3, Inserted: 3, Errors: []ImportRowError{{Row: 3, Key: "2"}}},
+			written: 3,
+			audited: 3,
+		},
+		{
+			name:     "taken since the check",
+			writeErr: duplicate,
+			want:     ImportResult{Valid: 2, Inserted: 2, Errors: []ImportRowError{{Row: 3, Key: "2"}, {Row: 4, Key: "3"}}},
+			written:  3,
+			audited:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeAuditStore{}
+			defer func(open func(context.Context) auditStore) { openAuditStore = open }(openAuditStore)
+			openAuditStore = func(context.Context) auditStore { return store }
+
+			collection := &fakeImportCollection{existing: []student{{Roll: "2"}}, writeErr: tt.writeErr}
+			rows := []importedRecord[student]{importRow(2, "1", "Ann"), importRow(3, "2", "Bob"), importRow(4, "3", "Cat"), importRow(5, "4", "Dan")}
+			result := &ImportResult{DryRun: tt.dryRun, Rows: 4, Errors: []ImportRowError{}}
+			if err := newStudents().importRows(context.Background(), httptest.NewRequest("POST", "/students/import", nil), collection, rows, result); err != nil {
+				t.Fatal(err)
+			}
+
+			// only the rows and keys matter here; TestDropExisting checks the messages
+			for i := range result.Errors {
+				result.Errors[i].Errors = nil
+			}
+			tt.want.Rows = 4
+			if !reflect.DeepEqual(*result, tt.want) {
+				t.Errorf("result = %+v, want %+v", *result, tt.want)
+			}
+			if len(collection.written) != tt.written {
+				t.Errorf("%d records written, want %d", len(collection.written), tt.written)
+			}
+			if len(store.entries) != tt.audited {
+				t.Errorf("%d audit entries, want %d", len(store.entries), tt.audited)
+			}
+			for _, model := range collection.written {
+				if s := model.(*mongo.InsertOneModel).Document.(*student); s.Version != 1 {
+					t.Errorf("%s inserted at version %d, want 1", s.Roll, s.Version)
+				}
+			}
+		})
+	}
+}
+
+func TestImportRowsFails(t *testing.T) {
+	down := errors.New("connection refused")
+	collection := &fakeImportCollection{writeErr: down}
+	result := &ImportResult{Rows: 1, Errors: []ImportRowError{}}
+	err := newStudents().importRows(context.Background(), httptest.NewRequest("POST", "/students/import", nil), collection, []importedRecord[student]{importRow(2, "1", "Ann")}, result)
+	if !errors.Is(err, down) {
+		t.Errorf("error = %v, want %v", err, down)
+	}
+}
@@ -0,0 +1,103 @@
+package crud
+
+import (
+	"context"
+	"fmt"
+	"kindergarten-core/database"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxReportedConflicts caps the keys an IndexConflictError lists.
+const maxReportedConflicts = 20
+
+// IndexConflict is a key value used by more than one record. A nil Key
+// stands for records without a key.
+type IndexConflict struct {
+	Key     interface{} `bson:"_id"`
+	Records int64       `bson:"records"`
+}
+
+// IndexConflictError is returned by EnsureIndexes when existing records keep
+// the unique index on the key from being built. They have to be cleaned up by
+// hand; until then Add and Import check for duplicates without the index.
+type IndexConflictError struct {
+	Collection string
+	Key        string
+	Conflicts  []IndexConflict
+	// More is set when there are more conflicts than Conflicts lists.
+	More bool
+}
+
+func (e *IndexConflictError) Error() string {
+	keys := make([]string, len(e.Conflicts))
+	for i, c := range e.Conflicts {
+		if c.Key == nil {
+			keys[i] = fmt.Sprintf("no %s (%d records)", e.Key, c.Records)
+		} else {
+			keys[i] = fmt.Sprintf("%q (%d records)", fmt.Sprint(c.Key), c.Records)
+		}
+	}
+	if e.More {
+		keys = append(keys, "…")
+	}
+	return fmt.Sprintf("unique %s index on %s blocked by records sharing a %s: %s", e.Key, e.Collection, e.Key, strings.Join(keys, ", "))
+}
+
+// EnsureIndexes creates the indexes the resource relies on: the indexes of
+// the audit log and a unique index on the key, which backs the duplicate
+// checks of Add and Import. When records sharing a key block the unique index
+// it returns an *IndexConflictError naming them. Once it has succeeded it
+// returns at once.
+func (res *Resource[T, P]) EnsureIndexes(ctx context.Context) error {
+	if res.indexed.Load() {
+		return nil
+	}
+
+	_, err := database.Collection(ctx, auditCollection).Indexes().CreateMany(ctx, auditIndexes)
+	if err != nil {
+		return fmt.Errorf("%s indexes: %w", auditCollection, err)
+	}
+	_, err = res.collection(ctx).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: res.Key, Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		conflicts, findErr := res.indexConflicts(ctx)
+		if findErr != nil {
+			return fmt.Errorf("unique %s index on %s: %w; listing the duplicates failed: %v", res.Key, res.Collection, err, findErr)
+		}
+		return conflicts
+	}
+	if err != nil {
+		return fmt.Errorf("unique %s index on %s: %w", res.Key, res.Collection, err)
+	}
+
+	res.indexed.Store(true)
+	return nil
+}
+
+// indexConflicts finds the key values shared by more than one record,
+// including records in the trash and records without a key.
+func (res *Resource[T, P]) indexConflicts(ctx context.Context) (*IndexConflictError, error) {
+	cursor, err := res.collection(ctx).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$" + res.Key}, {Key: "records", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+		{{Key: "$match", Value: bson.D{{Key: "records", Value: bson.D{{Key: "$gt", Value: 1}}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+		{{Key: "$limit", Value: maxReportedConflicts + 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	conflict := &IndexConflictError{Collection: res.Collection, Key: res.Key}
+	if err := cursor.All(ctx, &conflict.Conflicts); err != nil {
+		return nil, err
+	}
+	if len(conflict.Conflicts) > maxReportedConflicts {
+		conflict.Conflicts, conflict.More = conflict.Conflicts[:maxReportedConflicts], true
+	}
+	return conflict, nil
+}
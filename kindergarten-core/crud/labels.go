@@ -0,0 +1,63 @@
+package crud
+
+import (
+	"kindergarten-core/tracing"
+	"net/http"
+	"strings"
+)
+
+// problemOutcomes name how a request that got a problem response ended.
+var problemOutcomes = map[int]string{
+	http.StatusBadRequest:          "invalid",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusPreconditionFailed:  "precondition_failed",
+	http.StatusInternalServerError: "error",
+	http.StatusServiceUnavailable:  "unavailable",
+}
+
+// labelled labels the transaction of every request to handler with the user
+// making it and, unless empty, the entity it is about.
+func labelled(entity string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tracing.SetLabel(r.Context(), "user", actor(r))
+		if entity != "" {
+			tracing.SetLabel(r.Context(), "entity", entity)
+		}
+		handler(w, r)
+	}
+}
+
+// labelOutcome labels the transaction of r with how the request ended, e.g.
+// created or not_found.
+func labelOutcome(r *http.Request, outcome string) {
+	tracing.SetLabel(r.Context(), "outcome", outcome)
+}
+
+// labelList labels the transaction of a list request with the number of
+// records it returned.
+func labelList(r *http.Request, returned int) {
+	tracing.SetLabel(r.Context(), "result_count", returned)
+	labelOutcome(r, "listed")
+}
+
+// labelProblem labels the transaction of r with the outcome of a problem
+// response and counts the rejections there are custom metrics for.
+func labelProblem(r *http.Request, status int, code string) {
+	outcome, ok := problemOutcomes[status]
+	if !ok {
+		outcome = code
+	}
+	labelOutcome(r, outcome)
+
+	switch {
+	case strings.HasPrefix(code, "duplicate_"):
+		tracing.Count(r.Context(), tracing.DuplicateRejections, 1)
+	case code == codeValidationFailed:
+		tracing.Count(r.Context(), tracing.ValidationFailures, 1)
+	case code == codePreconditionFailed:
+		tracing.Count(r.Context(), tracing.VersionConflicts, 1)
+	}
+}
@@ -0,0 +1,102 @@
+package crud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// readMergePatch decodes an RFC 7386 JSON merge-patch document from the request
+// body. Only the given fields may appear in the patch. The non-null members are
+// decoded into model and validated like a full record; members set to null are
+// checked as empty values, so required fields cannot be removed. The returned
+// patch carries the trimmed values.
+func readMergePatch(r *http.Request, fields []string, model interface{}) (map[string]interface{}, []FieldError, error) {
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || (mediaType != "application/merge-patch+json" && mediaType != "application/json") {
+			return nil, nil, errors.New("content type must be application/merge-patch+json")
+		}
+	}
+
+	var members map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&members); err != nil || members == nil {
+		return nil, nil, errors.New("patch must be a JSON object")
+	}
+
+	invalid := []FieldError{}
+	values := map[string]json.RawMessage{}
+	for field, raw := range members {
+		if !contains(fields, field) {
+			invalid = append(invalid, FieldError{Field: field, Code: "unknown", Message: "unknown field"})
+			continue
+		}
+		if string(bytes.TrimSpace(raw)) != "null" {
+			values[field] = raw
+		}
+	}
+
+	mistyped, typeErrors := decodeMembers(values, model)
+	invalid = append(invalid, typeErrors...)
+	var check []string
+	for field := range members {
+		if contains(fields, field) && !mistyped[field] {
+			check = append(check, field)
+		}
+	}
+	invalid = append(invalid, validateFields(model, check)...)
+	if len(invalid) > 0 {
+		return nil, invalid, nil
+	}
+
+	patch := map[string]interface{}{}
+	for _, f := range modelFields(model) {
+		if _, ok := members[f.name]; !ok {
+			continue
+		}
+		if _, ok := values[f.name]; ok {
+			patch[f.name] = f.value.Interface()
+		} else {
+			patch[f.name] = nil
+		}
+	}
+	return patch, nil, nil
+}
+
+// mergePatchUpdate converts a merge-patch document into a Mongo update: members
+// set to null are removed, nested objects are merged member by member and every
+// other value replaces the stored one. It returns nil for an empty patch.
+func mergePatchUpdate(patch map[string]interface{}) bson.M {
+	set, unset := bson.M{}, bson.M{}
+	flattenMergePatch("", patch, set, unset)
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	return update
+}
+
+func flattenMergePatch(prefix string, patch map[string]interface{}, set, unset bson.M) {
+	for field, value := range patch {
+		path := prefix + field
+		switch v := value.(type) {
+		case nil:
+			unset[path] = ""
+		case map[string]interface{}:
+			flattenMergePatch(path+".", v, set, unset)
+		default:
+			set[path] = v
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package crud
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// student is a model like the services', with one field that is optional.
+type student struct {
+	Meta     `bson:",inline"`
+	Name     string `json:"name" validate:"required,max=100,chars=name"`
+	Roll     string `json:"roll" validate:"required,max=20,chars=key"`
+	Address  string `json:"address" validate:"required,max=200,chars=text"`
+	Nickname string `json:"nickname" validate:"max=20,chars=name"`
+}
+
+var studentFields = []string{"name", "roll", "address", "nickname"}
+
+func TestReadMergePatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        map[string]interface{}
+		invalid     []FieldError
+	}{
+		{
+			name:        "trimmed values",
+			contentType: "application/merge-patch+json",
+			body:        `{"name": "  Ann Rahman ", "address": "12 Lake Road"}`,
+			want:        map[string]interface{}{"name": "Ann Rahman", "address": "12 Lake Road"},
+		},
+		{
+			name:        "null removes an optional field",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"nickname": null}`,
+			want:        map[string]interface{}{"nickname": nil},
+		},
+		{
+			name: "no content type",
+			body: `{}`,
+			want: map[string]interface{}{},
+		},
+		{
+			name:    "null on a required field",
+			body:    `{"roll": null}`,
+			invalid: []FieldError{{Field: "roll", Code: "required", Message: "roll is required"}},
+		},
+		{
+			name:    "unknown field",
+			body:    `{"version": 3}`,
+			invalid: []FieldError{{Field: "version", Code: "unknown", Message: "unknown field"}},
+		},
+		{
+			name:    "wrong type is not validated further",
+			body:    `{"name": 5}`,
+			invalid: []FieldError{{Field: "name", Code: "type", Message: "must be a string"}},
+		},
+		{
+			name:    "rule broken",
+			body:    `{"roll": "12 A"}`,
+			invalid: []FieldError{{Field: "roll", Code: "invalid_characters", Message: "roll contains characters that are not allowed"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PATCH", "/students/7", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			patch, invalid, err := readMergePatch(r, studentFields, &student{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(invalid, tt.invalid) {
+				t.Errorf("invalid = %+v, want %+v", invalid, tt.invalid)
+			}
+			if !reflect.DeepEqual(patch, tt.want) {
+				t.Errorf("patch = %#v, want %#v", patch, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadMergePatchErrors(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+		want        string
+	}{
+		{"text/plain", `{"name": "Ann"}`, "content type must be application/merge-patch+json"},
+		{"application/json-patch+json", `[{"op": "remove", "path": "/name"}]`, "content type must be application/merge-patch+json"},
+		{"application/merge-patch+json", `[]`, "patch must be a JSON object"},
+		{"application/merge-patch+json", `null`, "patch must be a JSON object"},
+		{"application/merge-patch+json", `{"name": `, "patch must be a JSON object"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PATCH", "/students/7", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", tt.contentType)
+		if _, _, err := readMergePatch(r, studentFields, &student{}); err == nil || err.Error() != tt.want {
+			t.Errorf("%s %s: error = %v, want %q", tt.contentType, tt.body, err, tt.want)
+		}
+	}
+}
+
+func TestMergePatchUpdate(t *testing.T) {
+	tests := []struct {
+		name  string
+		patch map[string]interface{}
+		want  bson.M
+	}{
+		{"empty", map[string]interface{}{}, nil},
+		{"set", map[string]interface{}{"name": "Ann"}, bson.M{"$set": bson.M{"name": "Ann"}}},
+		{"unset", map[string]interface{}{"nickname": nil}, bson.M{"$unset": bson.M{"nickname": ""}}},
+		{
+			name:  "nested objects merge",
+			patch: map[string]interface{}{"name": "Ann", "guardian": map[string]interface{}{"phone": "555", "email": nil}},
+			want: bson.M{
+				"$set":   bson.M{"name": "Ann", "guardian.phone": "555"},
+				"$unset": bson.M{"guardian.email": ""},
+			},
+		},
+		{"empty nested object", map[string]interface{}{"guardian": map[string]interface{}{}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergePatchUpdate(tt.patch); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("update = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package crud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"kindergarten-core/logging"
+	"kindergarten-core/tracing"
+	"log/slog"
+	"net/http"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Error codes are part of the API; clients match on them, so never rename one.
+// Resources add duplicate_<key>, e.g. duplicate_roll.
+const (
+	codeInvalidRequest     = "invalid_request"
+	codeValidationFailed   = "validation_failed"
+	codeNotFound           = "not_found"
+	codePreconditionFailed = "precondition_failed"
+	codeMethodNotAllowed   = "method_not_allowed"
+	codeTooLarge           = "payload_too_large"
+	codeUnauthorized       = "unauthorized"
+	codeAuthUnavailable    = "auth_unavailable"
+	codeDBUnavailable      = "db_unavailable"
+	codeAuditFailed        = "audit_failed"
+	codeInternal           = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details body.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Code     string       `json:"code"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+	TraceID  string       `json:"trace_id,omitempty"`
+	// RequestID is the ID of the request in the log and the audit log
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// FieldError points at a single bad field of the request.
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeProblem replaces http.Error: it sends an application/problem+json body
+// carrying a stable error code and the trace and request IDs of the request.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string, fields ...FieldError) {
+	problem := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Code:      code,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Errors:    fields,
+		TraceID:   traceID(r),
+		RequestID: logging.RequestID(r.Context()),
+	}
+
+	labelProblem(r, status, code)
+
+	w.Header().Del("ETag")
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// writeDBError reports a failed database call. The driver's message stays in
+// the log; clients only get a code telling them whether retrying makes sense.
+func writeDBError(w http.ResponseWriter, r *http.Request, err error) {
+	if dbErrorCode(err) == codeDBUnavailable {
+		slog.WarnContext(r.Context(), "Database unavailable", "error", err)
+		writeProblem(w, r, http.StatusServiceUnavailable, codeDBUnavailable, "The database is unavailable, try again later")
+		return
+	}
+	slog.ErrorContext(r.Context(), "Database error", "error", err)
+	writeProblem(w, r, http.StatusInternalServerError, codeInternal, "Unexpected database error")
+}
+
+// dbErrorCode is db_unavailable for errors worth retrying, such as timeouts
+// and lost connections, and internal_error for the rest.
+func dbErrorCode(err error) string {
+	switch {
+	case mongo.IsNetworkError(err), mongo.IsTimeout(err), errors.Is(err, context.DeadlineExceeded), errors.Is(err, mongo.ErrClientDisconnected):
+		return codeDBUnavailable
+	default:
+		return codeInternal
+	}
+}
+
+// MethodNotAllowed is the problem response for routes hit with the wrong method.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, r.Method+" is not supported on this route")
+}
+
+// Unauthorized is the problem response for requests without a valid bearer
+// token; detail says what was wrong with it.
+func Unauthorized(w http.ResponseWriter, r *http.Request, detail string) {
+	writeProblem(w, r, http.StatusUnauthorized, codeUnauthorized, detail)
+}
+
+// AuthUnavailable is the problem response while tokens cannot be checked.
+func AuthUnavailable(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusServiceUnavailable, codeAuthUnavailable, "Authentication is unavailable, try again later")
+}
+
+// InternalError is the problem response for a handler that failed in a way
+// it did not expect, such as a panic.
+func InternalError(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusInternalServerError, codeInternal, "Unexpected error")
+}
+
+// traceID names the APM trace of the request so a problem report can be looked
+// up in Kibana. Requests without a transaction fall back to their request ID.
+func traceID(r *http.Request) string {
+	if id := tracing.TraceID(r.Context()); id != "" {
+		return id
+	}
+	return logging.RequestID(r.Context())
+}
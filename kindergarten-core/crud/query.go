@@ -0,0 +1,220 @@
+package crud
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxPageSize caps the limit parameter.
+const maxPageSize = 500
+
+// listQuery holds the pagination, sorting and filtering options of a list request.
+type listQuery struct {
+	Filter bson.M
+	Sort   bson.D
+	// Limit is 0 when every record is wanted
+	Limit  int64
+	Offset int64
+	// After holds the sort values of the last record of the previous page,
+	// from the cursor parameter
+	After bson.D
+}
+
+// parseListQuery reads limit, offset or cursor, sort and per-field filters
+// from the request. Only the given fields may be used for filtering and
+// sorting. Without limit every matching record is returned.
+//
+//	?limit=20&cursor=<token>&sort=-name,address&address=dhaka
+//
+// A cursor, taken from X-Next-Cursor or the Link header of the previous page,
+// continues after the last record of that page, so records added or deleted
+// meanwhile do not shift the pages. It only fits the sort it was made for.
+// offset skips records instead, which is slower for deep pages.
+func parseListQuery(r *http.Request, fields []string, defaultSort string) (listQuery, error) {
+	params := r.URL.Query()
+	q := listQuery{Filter: bson.M{}}
+
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 1 {
+			return q, errors.New("limit must be a positive integer")
+		}
+		if limit > maxPageSize {
+			limit = maxPageSize
+		}
+		q.Limit = limit
+	}
+
+	sortParam := params.Get("sort")
+	if sortParam == "" {
+		sortParam = defaultSort
+	}
+	for _, s := range strings.Split(sortParam, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		order := 1
+		if strings.HasPrefix(s, "-") {
+			order = -1
+			s = s[1:]
+		}
+		if !contains(fields, s) {
+			return q, fmt.Errorf("cannot sort by %q", s)
+		}
+		q.Sort = append(q.Sort, bson.E{Key: s, Value: order})
+	}
+	// _id keeps the order stable between pages when sort keys are equal
+	q.Sort = append(q.Sort, bson.E{Key: "_id", Value: 1})
+
+	if v := params.Get("cursor"); v != "" {
+		after, err := decodeCursor(v, q.Sort)
+		if err != nil {
+			return q, errors.New("invalid cursor")
+		}
+		q.After = after
+	} else if v := params.Get("offset"); v != "" {
+		offset, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || offset < 0 {
+			return q, errors.New("offset must be a non-negative integer")
+		}
+		q.Offset = offset
+	}
+
+	for _, field := range fields {
+		if v := strings.TrimSpace(params.Get(field)); v != "" {
+			q.Filter[field] = primitive.Regex{Pattern: regexp.QuoteMeta(v), Options: "i"}
+		}
+	}
+
+	return q, nil
+}
+
+// findFilter is Filter narrowed to the records after the cursor.
+func (q listQuery) findFilter() bson.M {
+	if q.After == nil {
+		return q.Filter
+	}
+	return bson.M{"$and": bson.A{q.Filter, afterFilter(q.Sort, q.After)}}
+}
+
+// afterFilter matches the records that sort after the values in after:
+// those past it on the first key, or equal on the first key and past it on
+// the second, and so on. Missing values sort before all others, as in Mongo.
+func afterFilter(sort, after bson.D) bson.M {
+	var or bson.A
+	equal := bson.D{}
+	for i, key := range sort {
+		value := after[i].Value
+		switch {
+		case value == nil && key.Value == 1:
+			or = append(or, append(equal[:len(equal):len(equal)], bson.E{Key: key.Key, Value: bson.M{"$ne": nil}}))
+		case value != nil && key.Value == 1:
+			or = append(or, append(equal[:len(equal):len(equal)], bson.E{Key: key.Key, Value: bson.M{"$gt": value}}))
+		case value != nil:
+			or = append(or,
+				append(equal[:len(equal):len(equal)], bson.E{Key: key.Key, Value: bson.M{"$lt": value}}),
+				append(equal[:len(equal):len(equal)], bson.E{Key: key.Key, Value: bson.M{"$eq": nil}}))
+		}
+		// $eq keeps a document value from being read as operators
+		equal = append(equal, bson.E{Key: key.Key, Value: bson.M{"$eq": value}})
+	}
+	if len(or) == 0 {
+		// nothing sorts after the last record
+		return bson.M{"_id": bson.M{"$exists": false}}
+	}
+	return bson.M{"$or": or}
+}
+
+// findOptions turns the query into Mongo find options. A page fetches one
+// record more than it returns, to tell whether there is a next page.
+func (q listQuery) findOptions() *options.FindOptions {
+	opts := options.Find().SetSort(q.Sort).SetSkip(q.Offset)
+	if q.Limit > 0 {
+		opts.SetLimit(q.Limit + 1)
+	}
+	return opts
+}
+
+// page cuts the records found with findOptions to the page and returns the
+// cursor to the next page, which is empty on the last one.
+func (q listQuery) page(records []bson.Raw) ([]bson.Raw, string) {
+	if q.Limit == 0 || int64(len(records)) <= q.Limit {
+		return records, ""
+	}
+	records = records[:q.Limit]
+	return records, encodeCursor(q.Sort, records[len(records)-1])
+}
+
+// writePageHeaders sets X-Total-Count and, when more results exist, an RFC 8288
+// Link header plus X-Next-Cursor pointing at the next page.
+func writePageHeaders(w http.ResponseWriter, r *http.Request, q listQuery, total int64, returned int, next string) {
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	labelList(r, returned)
+	if next == "" {
+		return
+	}
+
+	params := r.URL.Query()
+	params.Del("offset")
+	params.Set("cursor", next)
+	params.Set("limit", strconv.FormatInt(q.Limit, 10))
+	nextURL := url.URL{Path: r.URL.Path, RawQuery: params.Encode()}
+
+	w.Header().Set("X-Next-Cursor", next)
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL.String()))
+}
+
+// encodeCursor encodes the values of the sort keys of record.
+func encodeCursor(sort bson.D, record bson.Raw) string {
+	after := bson.D{}
+	for _, key := range sort {
+		var value interface{}
+		if v, err := record.LookupErr(key.Key); err == nil && v.Type != bson.TypeNull {
+			value = v
+		}
+		after = append(after, bson.E{Key: key.Key, Value: value})
+	}
+	b, _ := bson.Marshal(after)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor returns the values in token, which must be for the keys of sort.
+func decodeCursor(token string, sort bson.D) (bson.D, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	var after bson.D
+	if err := bson.Unmarshal(raw, &after); err != nil {
+		return nil, err
+	}
+	if len(after) != len(sort) {
+		return nil, errors.New("cursor for another sort")
+	}
+	for i, key := range sort {
+		if after[i].Key != key.Key {
+			return nil, errors.New("cursor for another sort")
+		}
+	}
+	return after, nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
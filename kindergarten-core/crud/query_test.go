@@ -0,0 +1,220 @@
+package crud
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var listFields = []string{"roll", "name", "address"}
+
+var byRoll = bson.D{{Key: "roll", Value: 1}, {Key: "_id", Value: 1}}
+
+func mustMarshal(t *testing.T, doc interface{}) bson.Raw {
+	t.Helper()
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseListQuery(t *testing.T) {
+	id := primitive.NewObjectID()
+	cursor := encodeCursor(byRoll, mustMarshal(t, bson.D{{Key: "_id", Value: id}, {Key: "roll", Value: "7"}, {Key: "name", Value: "Ann"}}))
+	tests := []struct {
+		name  string
+		query string
+		want  listQuery
+	}{
+		{
+			name:  "everything by default",
+			query: "",
+			want:  listQuery{Filter: bson.M{}, Sort: byRoll},
+		},
+		{
+			name:  "limit and offset",
+			query: "limit=20&offset=40",
+			want:  listQuery{Filter: bson.M{}, Sort: byRoll, Limit: 20, Offset: 40},
+		},
+		{
+			name:  "limit capped",
+			query: "limit=100000",
+			want:  listQuery{Filter: bson.M{}, Sort: byRoll, Limit: maxPageSize},
+		},
+		{
+			name:  "cursor wins over offset",
+			query: "limit=20&cursor=" + cursor + "&offset=5",
+			want:  listQuery{Filter: bson.M{}, Sort: byRoll, Limit: 20, After: bson.D{{Key: "roll", Value: "7"}, {Key: "_id", Value: id}}},
+		},
+		{
+			name:  "sort",
+			query: "sort=-name,,+address+",
+			want:  listQuery{Filter: bson.M{}, Sort: bson.D{{Key: "name", Value: -1}, {Key: "address", Value: 1}, {Key: "_id", Value: 1}}},
+		},
+		{
+			name:  "filters are literal and case-insensitive",
+			query: "address=%20Dhaka.*%20&name=&grade=3",
+			want: listQuery{
+				Filter: bson.M{"address": primitive.Regex{Pattern: `Dhaka\.\*`, Options: "i"}},
+				Sort:   byRoll,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseListQuery(httptest.NewRequest("GET", "/students?"+tt.query, nil), listFields, "roll")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("query = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseListQueryErrors(t *testing.T) {
+	byName := encodeCursor(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}}, mustMarshal(t, bson.D{{Key: "name", Value: "Ann"}}))
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"limit=0", "limit must be a positive integer"},
+		{"limit=ten", "limit must be a positive integer"},
+		{"offset=-1", "offset must be a non-negative integer"},
+		{"cursor=not-a-cursor", "invalid cursor"},
+		{"cursor=" + byName, "invalid cursor"},
+		{"sort=password", `cannot sort by "password"`},
+		{"sort=-_id", `cannot sort by "_id"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := parseListQuery(httptest.NewRequest("GET", "/students?"+tt.query, nil), listFields, "roll")
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	sort := bson.D{{Key: "name", Value: -1}, {Key: "address", Value: 1}, {Key: "_id", Value: 1}}
+	id := primitive.NewObjectID()
+	record := mustMarshal(t, bson.D{{Key: "_id", Value: id}, {Key: "roll", Value: "7"}, {Key: "name", Value: "Ann"}, {Key: "address", Value: nil}})
+
+	after, err := decodeCursor(encodeCursor(sort, record), sort)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.D{{Key: "name", Value: "Ann"}, {Key: "address", Value: nil}, {Key: "_id", Value: id}}
+	if !reflect.DeepEqual(after, want) {
+		t.Errorf("after = %v, want %v", after, want)
+	}
+
+	if _, err := decodeCursor(encodeCursor(sort, record), byRoll); err == nil {
+		t.Error("cursor accepted for another sort")
+	}
+	for _, token := range []string{
+		"",
+		"%%%",
+		base64.RawURLEncoding.EncodeToString([]byte("o:50")),
+		encodeCursor(sort, record) + "x",
+	} {
+		if after, err := decodeCursor(token, sort); err == nil {
+			t.Errorf("decodeCursor(%q) = %v, want an error", token, after)
+		}
+	}
+}
+
+func TestAfterFilter(t *testing.T) {
+	id := primitive.NewObjectID()
+	byName := bson.D{{Key: "name", Value: -1}, {Key: "_id", Value: 1}}
+	tests := []struct {
+		name  string
+		sort  bson.D
+		after bson.D
+		want  bson.M
+	}{
+		{
+			name:  "ascending",
+			sort:  byRoll,
+			after: bson.D{{Key: "roll", Value: "7"}, {Key: "_id", Value: id}},
+			want: bson.M{"$or": bson.A{
+				bson.D{{Key: "roll", Value: bson.M{"$gt": "7"}}},
+				bson.D{{Key: "roll", Value: bson.M{"$eq": "7"}}, {Key: "_id", Value: bson.M{"$gt": id}}},
+			}},
+		},
+		{
+			name:  "descending, where missing values come last",
+			sort:  byName,
+			after: bson.D{{Key: "name", Value: "Ann"}, {Key: "_id", Value: id}},
+			want: bson.M{"$or": bson.A{
+				bson.D{{Key: "name", Value: bson.M{"$lt": "Ann"}}},
+				bson.D{{Key: "name", Value: bson.M{"$eq": nil}}},
+				bson.D{{Key: "name", Value: bson.M{"$eq": "Ann"}}, {Key: "_id", Value: bson.M{"$gt": id}}},
+			}},
+		},
+		{
+			name:  "after a missing value ascending",
+			sort:  byRoll,
+			after: bson.D{{Key: "roll", Value: nil}, {Key: "_id", Value: id}},
+			want: bson.M{"$or": bson.A{
+				bson.D{{Key: "roll", Value: bson.M{"$ne": nil}}},
+				bson.D{{Key: "roll", Value: bson.M{"$eq": nil}}, {Key: "_id", Value: bson.M{"$gt": id}}},
+			}},
+		},
+		{
+			name:  "after a missing value descending",
+			sort:  byName,
+			after: bson.D{{Key: "name", Value: nil}, {Key: "_id", Value: id}},
+			want: bson.M{"$or": bson.A{
+				bson.D{{Key: "name", Value: bson.M{"$eq": nil}}, {Key: "_id", Value: bson.M{"$gt": id}}},
+			}},
+		},
+		{
+			name:  "operators in a cursor are values",
+			sort:  byRoll,
+			after: bson.D{{Key: "roll", Value: bson.D{{Key: "$ne", Value: ""}}}, {Key: "_id", Value: id}},
+			want: bson.M{"$or": bson.A{
+				bson.D{{Key: "roll", Value: bson.M{"$gt": bson.D{{Key: "$ne", Value: ""}}}}},
+				bson.D{{Key: "roll", Value: bson.M{"$eq": bson.D{{Key: "$ne", Value: ""}}}}, {Key: "_id", Value: bson.M{"$gt": id}}},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := afterFilter(tt.sort, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filter =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPage(t *testing.T) {
+	var records []bson.Raw
+	for _, roll := range []string{"1", "2", "3"} {
+		records = append(records, mustMarshal(t, bson.D{{Key: "_id", Value: roll}, {Key: "roll", Value: roll}}))
+	}
+
+	q := listQuery{Sort: byRoll, Limit: 2}
+	page, next := q.page(records)
+	if len(page) != 2 {
+		t.Fatalf("page of %d records, want 2", len(page))
+	}
+	after, err := decodeCursor(next, byRoll)
+	if err != nil || !reflect.DeepEqual(after, bson.D{{Key: "roll", Value: "2"}, {Key: "_id", Value: "2"}}) {
+		t.Errorf("next page after %v, %v, want after roll 2", after, err)
+	}
+
+	if page, next := q.page(records[:2]); len(page) != 2 || next != "" {
+		t.Errorf("last page: %d records, cursor %q, want 2 and none", len(page), next)
+	}
+	if page, next := (listQuery{Sort: byRoll}).page(records); len(page) != 3 || next != "" {
+		t.Errorf("without a limit: %d records, cursor %q, want all and none", len(page), next)
+	}
+}
@@ -0,0 +1,175 @@
+// Package crud serves a Mongo collection as a REST resource: listing, single
+// record reads, create, update, merge patch, soft delete with trash and
+// restore, CSV import, exports and the shared audit log.
+package crud
+
+import (
+	"context"
+	"fmt"
+	"kindergarten-core/database"
+	"kindergarten-core/tracing"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Config describes the model a Resource serves.
+type Config struct {
+	// Name is the capitalised singular used in messages, e.g. "Student".
+	Name string
+	// Collection is the Mongo collection, also the plural used in paths, e.g. "students".
+	Collection string
+	// Key is the JSON and BSON name of the field that identifies a record, e.g. "roll".
+	Key string
+	// KeyLabel is how messages refer to the key, e.g. "roll number".
+	KeyLabel string
+}
+
+// Meta holds the bookkeeping fields every record carries. Models embed it
+// with a `bson:",inline"` tag.
+type Meta struct {
+	Version   int64      `json:"version" bson:"version,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+}
+
+func (m *Meta) meta() *Meta {
+	return m
+}
+
+// Record is implemented by pointers to models that embed Meta.
+type Record[T any] interface {
+	*T
+	meta() *Meta
+}
+
+// Route is one endpoint, registered by the server package.
+type Route struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+}
+
+// Resource serves the endpoints for model T.
+type Resource[T any, P Record[T]] struct {
+	Config
+	// fields are the string fields of T; they can be filtered, sorted,
+	// patched, imported and exported
+	fields []string
+	entity string
+	// indexed is set once EnsureIndexes has succeeded
+	indexed atomic.Bool
+}
+
+// NewResource checks config against T and builds the resource. T must be a
+// struct embedding Meta with a string field named config.Key.
+func NewResource[T any, P Record[T]](config Config) *Resource[T, P] {
+	res := &Resource[T, P]{Config: config, entity: strings.ToLower(config.Name)}
+	for _, f := range modelFields(P(new(T))) {
+		if f.value.Kind() == reflect.String {
+			res.fields = append(res.fields, f.name)
+		}
+	}
+	if !contains(res.fields, config.Key) {
+		panic(fmt.Sprintf("crud: %s has no string field %q", config.Name, config.Key))
+	}
+	return res
+}
+
+// Routes lists the endpoints of the resource, for students:
+//
+//	GET    /students              list, see parseListQuery
+//	POST   /add-student           create
+//	PUT    /update-student        replace
+//	DELETE /delete-student?roll=  move to the trash
+//	GET    /students/{roll}       read one
+//	PATCH  /students/{roll}       JSON merge patch
+//	GET    /trash                 list the trash
+//	POST   /restore-student?roll= take out of the trash
+//	POST   /import-students       CSV import
+//	GET    /export-students       CSV, XLSX or NDJSON export
+//	GET    /audit                 audit log
+func (res *Resource[T, P]) Routes() []Route {
+	one := "/" + res.Collection + "/{" + res.Key + "}"
+	return []Route{
+		{http.MethodGet, "/" + res.Collection, labelled(res.entity, res.List)},
+		{http.MethodPost, "/add-" + res.entity, labelled(res.entity, res.Add)},
+		{http.MethodPut, "/update-" + res.entity, labelled(res.entity, res.Update)},
+		{http.MethodDelete, "/delete-" + res.entity, labelled(res.entity, res.Delete)},
+		{http.MethodGet, one, labelled(res.entity, res.Get)},
+		{http.MethodPatch, one, labelled(res.entity, res.Patch)},
+		{http.MethodGet, "/trash", labelled(res.entity, res.Trash)},
+		{http.MethodPost, "/restore-" + res.entity, labelled(res.entity, res.Restore)},
+		{http.MethodPost, "/import-" + res.Collection, labelled(res.entity, res.Import)},
+		{http.MethodGet, "/export-" + res.Collection, labelled(res.entity, res.Export)},
+		{http.MethodGet, "/audit", labelled("", GetAuditLog)},
+		{http.MethodGet, "/audit/verify", labelled("", VerifyAuditLog)},
+	}
+}
+
+// collection returns the resource's collection on the connection ctx pinned.
+func (res *Resource[T, P]) collection(ctx context.Context) *mongo.Collection {
+	return database.Collection(ctx, res.Collection)
+}
+
+// CollectionName returns the name of the collection holding the records.
+func (res *Resource[T, P]) CollectionName() string {
+	return res.Collection
+}
+
+// Count returns the number of records outside the trash, e.g. for metrics.
+func (res *Resource[T, P]) Count(ctx context.Context) (int64, error) {
+	return res.collection(ctx).CountDocuments(ctx, notDeleted(bson.M{}))
+}
+
+// plural is the capitalised collection name, used in span names and titles.
+func (res *Resource[T, P]) plural() string {
+	return strings.ToUpper(res.Collection[:1]) + res.Collection[1:]
+}
+
+// keyOf returns the key of a record.
+func (res *Resource[T, P]) keyOf(record P) string {
+	for _, f := range modelFields(record) {
+		if f.name == res.Key {
+			return f.value.String()
+		}
+	}
+	return ""
+}
+
+// duplicateCode is the error code for a key that is already taken, e.g. duplicate_roll.
+func (res *Resource[T, P]) duplicateCode() string {
+	return "duplicate_" + res.Key
+}
+
+func (res *Resource[T, P]) duplicateMessage() string {
+	return fmt.Sprintf("%s with this %s already exists", res.Name, res.KeyLabel)
+}
+
+// keyParam reads the key from the query string (?roll=) or, for paths with a
+// {roll} wildcard, from the path.
+func (res *Resource[T, P]) keyParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key := r.PathValue(res.Key)
+	if key == "" {
+		key = r.URL.Query().Get(res.Key)
+	}
+	if key == "" {
+		writeProblem(w, r, http.StatusBadRequest, codeValidationFailed, res.Key+" parameter missing", FieldError{Field: res.Key, Code: "required", Message: res.Key + " is required"})
+		return "", false
+	}
+	tracing.SetLabel(r.Context(), res.Key, key)
+	return key, true
+}
+
+// writeDBError is writeDBError with duplicate keys reported in terms of the resource.
+func (res *Resource[T, P]) writeDBError(w http.ResponseWriter, r *http.Request, err error) {
+	if mongo.IsDuplicateKeyError(err) {
+		writeProblem(w, r, http.StatusConflict, res.duplicateCode(), res.duplicateMessage())
+		return
+	}
+	writeDBError(w, r, err)
+}
@@ -0,0 +1,93 @@
+package crud
+
+import (
+	"context"
+	"kindergarten-core/config"
+	"kindergarten-core/database"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// trashPurgeActor is the actor of the audit entries of purged records.
+	trashPurgeActor = "system:trash-purge"
+	purgeTimeout    = 30 * time.Second
+)
+
+// notDeleted restricts filter to records that are not in the trash.
+func notDeleted(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$exists": false}
+	return filter
+}
+
+// StartTrashPurge removes records that have been in the trash longer than
+// cfg.Retention, checking every cfg.PurgeInterval until ctx is done. Each
+// purged record gets a "purge" entry in the audit log.
+func (res *Resource[T, P]) StartTrashPurge(ctx context.Context, cfg config.Trash) {
+	slog.Info("Purging trash", "collection", res.Collection, "retention", cfg.Retention.String())
+
+	go func() {
+		ticker := time.NewTicker(cfg.PurgeInterval)
+		defer ticker.Stop()
+		for {
+			res.purgeTrash(ctx, cfg.Retention)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// purgeTrash deletes the expired records one at a time so that each deletion
+// is audited. Once ctx is done it stops before the next record; a deletion
+// already made is still audited.
+func (res *Resource[T, P]) purgeTrash(ctx context.Context, retention time.Duration) {
+	work, release := database.Pin(context.WithoutCancel(ctx))
+	defer release()
+	work, cancel := context.WithTimeout(work, purgeTimeout)
+	defer cancel()
+
+	collection := res.collection(work)
+	expired := bson.M{"$lt": time.Now().UTC().Add(-retention)}
+	cursor, err := collection.Find(work, bson.M{"deleted_at": expired})
+	if err != nil {
+		slog.Error("Trash purge failed", "collection", res.Collection, "error", err)
+		return
+	}
+	defer cursor.Close(work)
+
+	purged := 0
+	for ctx.Err() == nil && cursor.Next(work) {
+		var record T
+		if err = cursor.Decode(&record); err != nil {
+			break
+		}
+		key := res.keyOf(&record)
+		// a record restored since the query stays
+		var result *mongo.DeleteResult
+		result, err = collection.DeleteOne(work, bson.M{res.Key: key, "deleted_at": expired})
+		if err != nil {
+			break
+		}
+		if result.DeletedCount == 1 {
+			purged++
+			// a failed entry is counted and logged; the purge goes on
+			writeAudit(work, auditEvent{entity: res.entity, key: key, action: "purge", actor: trashPurgeActor, before: &record})
+		}
+	}
+	if err == nil {
+		err = cursor.Err()
+	}
+	if err != nil {
+		slog.Error("Trash purge failed", "collection", res.Collection, "purged", purged, "error", err)
+		return
+	}
+	if purged > 0 {
+		slog.Info("Purged trash", "collection", res.Collection, "count", purged)
+	}
+}
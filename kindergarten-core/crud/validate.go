@@ -0,0 +1,159 @@
+package crud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Models declare their rules in a validate struct tag, for example
+// `validate:"required,max=20,chars=key"`:
+//
+//	required  the field may not be empty after trimming
+//	min=N     at least N characters
+//	max=N     at most N characters
+//	chars=X   only the characters of charset X (see validationCharsets)
+//
+// Every string field is trimmed before it is checked.
+var validationCharsets = map[string]*regexp.Regexp{
+	"name": regexp.MustCompile(`^[\p{L}\p{M}' .-]*$`),
+	"key":  regexp.MustCompile(`^[A-Za-z0-9_-]*$`),
+	"text": regexp.MustCompile(`^[^\p{Cc}]*$`),
+}
+
+// metaFields are the JSON names of Meta, accepted in request bodies but ignored.
+var metaFields = map[string]bool{"version": true, "deleted_at": true}
+
+type modelField struct {
+	name  string
+	rules string
+	value reflect.Value
+}
+
+// modelFields lists the JSON fields of the struct model points to, leaving out
+// the embedded Meta.
+func modelFields(model interface{}) []modelField {
+	v := reflect.ValueOf(model).Elem()
+	var fields []modelField
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Anonymous || name == "" || name == "-" {
+			continue
+		}
+		fields = append(fields, modelField{name: name, rules: f.Tag.Get("validate"), value: v.Field(i)})
+	}
+	return fields
+}
+
+// decodeValid decodes the JSON object in the request body into model and
+// validates it. Unknown members, wrongly typed members and rule violations are
+// all collected so the client can fix every field at once; the error is only
+// set when the body is not a JSON object at all.
+func decodeValid(r *http.Request, model interface{}) ([]FieldError, error) {
+	var members map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&members); err != nil || members == nil {
+		return nil, errors.New("request body must be a JSON object")
+	}
+
+	mistyped, invalid := decodeMembers(members, model)
+	var check []string
+	for _, f := range modelFields(model) {
+		if !mistyped[f.name] {
+			check = append(check, f.name)
+		}
+	}
+	return append(invalid, validateFields(model, check)...), nil
+}
+
+// decodeMembers decodes each member into the model field with the same JSON
+// name. It returns the fields whose member had the wrong type.
+func decodeMembers(members map[string]json.RawMessage, model interface{}) (map[string]bool, []FieldError) {
+	byName := map[string]reflect.Value{}
+	for _, f := range modelFields(model) {
+		byName[f.name] = f.value
+	}
+
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	mistyped := map[string]bool{}
+	invalid := []FieldError{}
+	for _, name := range names {
+		value, ok := byName[name]
+		if !ok && metaFields[name] {
+			// clients send back what they read; the server owns these
+			continue
+		}
+		if !ok {
+			invalid = append(invalid, FieldError{Field: name, Code: "unknown", Message: "unknown field"})
+			continue
+		}
+		if err := json.Unmarshal(members[name], value.Addr().Interface()); err != nil {
+			mistyped[name] = true
+			invalid = append(invalid, FieldError{Field: name, Code: "type", Message: "must be a " + value.Type().String()})
+		}
+	}
+	return mistyped, invalid
+}
+
+// validateFields trims the named string fields of model and checks them
+// against their validate tags.
+func validateFields(model interface{}, names []string) []FieldError {
+	invalid := []FieldError{}
+	for _, f := range modelFields(model) {
+		if !contains(names, f.name) || f.value.Kind() != reflect.String {
+			continue
+		}
+		s := strings.TrimSpace(f.value.String())
+		f.value.SetString(s)
+		if fe, ok := checkRules(f.name, s, f.rules); !ok {
+			invalid = append(invalid, fe)
+		}
+	}
+	return invalid
+}
+
+// checkRules returns the first rule s breaks.
+func checkRules(name, s, rules string) (FieldError, bool) {
+	length := utf8.RuneCountInString(s)
+	for _, rule := range strings.Split(rules, ",") {
+		key, arg, _ := strings.Cut(rule, "=")
+		switch key {
+		case "required":
+			if s == "" {
+				return FieldError{Field: name, Code: "required", Message: name + " is required"}, false
+			}
+		case "min", "max":
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				panic(fmt.Sprintf("bad %s rule on %s: %q", key, name, rule))
+			}
+			if key == "min" && s != "" && length < n {
+				return FieldError{Field: name, Code: "too_short", Message: fmt.Sprintf("%s must be at least %d characters", name, n)}, false
+			}
+			if key == "max" && length > n {
+				return FieldError{Field: name, Code: "too_long", Message: fmt.Sprintf("%s must be at most %d characters", name, n)}, false
+			}
+		case "chars":
+			charset, ok := validationCharsets[arg]
+			if !ok {
+				panic(fmt.Sprintf("unknown charset on %s: %q", name, arg))
+			}
+			if !charset.MatchString(s) {
+				return FieldError{Field: name, Code: "invalid_characters", Message: name + " contains characters that are not allowed"}, false
+			}
+		}
+	}
+	return FieldError{}, true
+}
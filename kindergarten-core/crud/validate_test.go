@@ -0,0 +1,105 @@
+package crud
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateFields(t *testing.T) {
+	valid := func() student {
+		return student{Name: "Ann O'Neil-Rahman", Roll: "KG_1-07", Address: "12 Lake Road, Dhaka"}
+	}
+	tests := []struct {
+		name   string
+		change func(s *student)
+		want   []FieldError
+	}{
+		{"valid", func(s *student) {}, []FieldError{}},
+		{"letters and marks of any script", func(s *student) { s.Name = "Ánh Nguyễn আনা" }, []FieldError{}},
+		{"required", func(s *student) { s.Roll = "" }, []FieldError{{Field: "roll", Code: "required", Message: "roll is required"}}},
+		{"blank is empty", func(s *student) { s.Address = " \t " }, []FieldError{{Field: "address", Code: "required", Message: "address is required"}}},
+		{"optional may be empty", func(s *student) { s.Nickname = "" }, []FieldError{}},
+		{"max counts characters", func(s *student) { s.Nickname = strings.Repeat("é", 20) }, []FieldError{}},
+		{"too long", func(s *student) { s.Roll = strings.Repeat("1", 21) }, []FieldError{{Field: "roll", Code: "too_long", Message: "roll must be at most 20 characters"}}},
+		{"key charset", func(s *student) { s.Roll = "7/B" }, []FieldError{{Field: "roll", Code: "invalid_characters", Message: "roll contains characters that are not allowed"}}},
+		{"name charset", func(s *student) { s.Name = "<b>Ann</b>" }, []FieldError{{Field: "name", Code: "invalid_characters", Message: "name contains characters that are not allowed"}}},
+		{"text charset", func(s *student) { s.Address = "12 Lake\nRoad" }, []FieldError{{Field: "address", Code: "invalid_characters", Message: "address contains characters that are not allowed"}}},
+		{
+			name:   "every field reported",
+			change: func(s *student) { s.Name, s.Roll = "", "a b" },
+			want: []FieldError{
+				{Field: "name", Code: "required", Message: "name is required"},
+				{Field: "roll", Code: "invalid_characters", Message: "roll contains characters that are not allowed"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := valid()
+			tt.change(&s)
+			if got := validateFields(&s, studentFields); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("invalid = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFieldsTrimsNamedFields(t *testing.T) {
+	s := student{Name: "  Ann ", Roll: " 7 ", Address: "\tLake Road\n"}
+	if invalid := validateFields(&s, []string{"name", "address"}); len(invalid) != 0 {
+		t.Fatalf("invalid = %+v", invalid)
+	}
+	if s.Name != "Ann" || s.Address != "Lake Road" {
+		t.Errorf("name, address = %q, %q, want them trimmed", s.Name, s.Address)
+	}
+	if s.Roll != " 7 " {
+		t.Errorf("roll = %q, want it left alone", s.Roll)
+	}
+}
+
+func TestValidateMin(t *testing.T) {
+	for s, want := range map[string]bool{"": true, "ab": false, "abc": true} {
+		if _, ok := checkRules("code", s, "min=3"); ok != want {
+			t.Errorf("min=3 on %q: ok = %v, want %v", s, ok, want)
+		}
+	}
+}
+
+func TestValidateBadRules(t *testing.T) {
+	for _, rules := range []string{"max=many", "min=", "chars=emoji"} {
+		t.Run(rules, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("rule %q accepted", rules)
+				}
+			}()
+			checkRules("name", "Ann", rules)
+		})
+	}
+}
+
+func TestDecodeValid(t *testing.T) {
+	body := `{"name": " Ann ", "roll": 7, "address": "", "grade": "KG", "version": 3}`
+	var s student
+	invalid, err := decodeValid(httptest.NewRequest("POST", "/students", strings.NewReader(body)), &s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FieldError{
+		{Field: "grade", Code: "unknown", Message: "unknown field"},
+		{Field: "roll", Code: "type", Message: "must be a string"},
+		{Field: "address", Code: "required", Message: "address is required"},
+	}
+	if !reflect.DeepEqual(invalid, want) {
+		t.Errorf("invalid = %+v, want %+v", invalid, want)
+	}
+	if s.Name != "Ann" || s.Version != 0 {
+		t.Errorf("decoded %+v, want the name trimmed and the version ignored", s)
+	}
+
+	if _, err := decodeValid(httptest.NewRequest("POST", "/students", strings.NewReader(`["Ann"]`)), &s); err == nil {
+		t.Error("array body accepted")
+	}
+}
@@ -0,0 +1,276 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kindergarten-core/config"
+	"kindergarten-core/metrics"
+	"kindergarten-core/tracing"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// drainTimeout is how long a replaced client waits at most for the requests
+// that pinned it before it is disconnected. It outlasts the longest request
+// deadline, the five minutes an export may take. Tests shorten it.
+var drainTimeout = 6 * time.Minute
+
+// drainPoll is how often a replaced client checks whether it is still in use.
+var drainPoll = time.Second
+
+// cleanupTimeout bounds disconnecting a replaced client.
+const cleanupTimeout = 5 * time.Second
+
+// connection is a client and the database selected on it. It is swapped as a
+// whole so no request ever sees the client of one and the database of another.
+// users counts the requests that pinned it.
+type connection struct {
+	client   *mongo.Client
+	database *mongo.Database
+	users    atomic.Int64
+}
+
+var current atomic.Pointer[connection]
+
+// notConnected stands in for the connection until Connect has stored one. Its
+// client is disconnected, so work done with it fails with
+// mongo.ErrClientDisconnected instead of dereferencing nil.
+var notConnected = sync.OnceValue(func() *connection {
+	client, _ := mongo.Connect(context.Background(), options.Client())
+	client.Disconnect(context.Background())
+	return &connection{client: client, database: client.Database("test")}
+})
+
+// ping checks that client reaches the primary; tests replace it.
+var ping = func(ctx context.Context, client *mongo.Client) error {
+	return client.Ping(ctx, readpref.Primary())
+}
+
+// load returns the current connection, or notConnected before Connect.
+func load() *connection {
+	if c := current.Load(); c != nil {
+		return c
+	}
+	return notConnected()
+}
+
+type connectionKey struct{}
+
+// Pin holds on to the current connection for the work done with the returned
+// context, so a Reconnect in the meantime does not disconnect it. Call release
+// when that work is done.
+func Pin(ctx context.Context) (pinned context.Context, release func()) {
+	for {
+		c := current.Load()
+		if c == nil {
+			return ctx, func() {}
+		}
+		c.users.Add(1)
+		// a connection swapped out before it was counted may already be
+		// disconnecting, so only keep it while it is still current
+		if current.Load() == c {
+			var once sync.Once
+			return context.WithValue(ctx, connectionKey{}, c), func() {
+				once.Do(func() { c.users.Add(-1) })
+			}
+		}
+		c.users.Add(-1)
+	}
+}
+
+// Middleware pins the current connection for each request.
+func Middleware(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, release := Pin(r.Context())
+		defer release()
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+// Collection returns the named collection on the connection ctx pinned, or on
+// the current one.
+func Collection(ctx context.Context, name string) *mongo.Collection {
+	if c, ok := ctx.Value(connectionKey{}).(*connection); ok {
+		return c.database.Collection(name)
+	}
+	return Database().Collection(name)
+}
+
+// Client returns the current MongoDB client. Before Connect it is a
+// disconnected client whose operations fail with mongo.ErrClientDisconnected.
+func Client() *mongo.Client {
+	return load().client
+}
+
+// Database returns the configured database on the current client, or on the
+// disconnected one before Connect.
+func Database() *mongo.Database {
+	return load().database
+}
+
+// Connect opens the MongoDB connection and selects the configured database.
+// It pings MongoDB until it answers, giving each attempt cfg.ConnectTimeout
+// and backing off exponentially with jitter from cfg.RetryInitial up to
+// cfg.RetryMax, for as long as cfg.StartupBudget allows. Once the budget is
+// spent it fails, or with cfg.DegradedStart keeps the client anyway so the
+// driver connects in the background and Ping reports the outage meanwhile.
+func Connect(ctx context.Context, cfg config.Mongo) error {
+	// mongo.Connect does no I/O, so its errors are configuration errors
+	client, err := mongo.Connect(ctx, clientOptions(cfg))
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(cfg.StartupBudget)
+	backoff := cfg.RetryInitial
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout)
+		err = ping(attemptCtx, client)
+		cancel()
+		if err == nil {
+			break
+		}
+
+		wait := jitter(backoff)
+		left := time.Until(deadline)
+		if ctx.Err() != nil || left < wait {
+			if !cfg.DegradedStart || ctx.Err() != nil {
+				client.Disconnect(context.Background())
+				return fmt.Errorf("MongoDB unreachable after %d attempts: %w", attempt, err)
+			}
+			slog.Warn("MongoDB unreachable, starting degraded and connecting in the background", "attempts", attempt, "error", err)
+			current.Store(&connection{client: client, database: client.Database(cfg.Database)})
+			return nil
+		}
+		slog.Warn("MongoDB connection attempt failed", "attempt", attempt, "retry_in", wait.Round(time.Millisecond).String(),
+			"budget_left", left.Round(time.Second).String(), "error", err)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+		backoff = min(2*backoff, cfg.RetryMax)
+	}
+
+	current.Store(&connection{client: client, database: client.Database(cfg.Database)})
+	slog.Info("Connected to MongoDB", "database", cfg.Database)
+	return nil
+}
+
+// jitter picks a delay between half of d and d, so replicas started together
+// do not retry in lockstep.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + rand.N(d/2+1)
+}
+
+// Reconnect opens a new client, for example with rotated credentials, and
+// swaps it in once it answers a ping. Requests that pinned the old client
+// keep it until they finish, or for drainTimeout at most, and then it is
+// disconnected; a failed reconnect leaves the current client in place.
+func Reconnect(cfg config.Mongo) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ConnectTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions(cfg))
+	if err != nil {
+		return err
+	}
+	if err := ping(ctx, client); err != nil {
+		client.Disconnect(context.Background())
+		return err
+	}
+
+	old := current.Swap(&connection{client: client, database: client.Database(cfg.Database)})
+	slog.Info("Reconnected to MongoDB", "user", cfg.Username, "database", cfg.Database)
+	if old != nil {
+		go retire(old)
+	}
+	return nil
+}
+
+// retire disconnects a replaced connection once no request has it pinned, or
+// once drainTimeout has passed.
+func retire(old *connection) {
+	deadline := time.Now().Add(drainTimeout)
+	ticker := time.NewTicker(drainPoll)
+	defer ticker.Stop()
+	for old.users.Load() > 0 && time.Now().Before(deadline) {
+		<-ticker.C
+	}
+	if users := old.users.Load(); users > 0 {
+		slog.Warn("Replaced MongoDB client still in use, disconnecting anyway", "requests", users, "drain_timeout", drainTimeout.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := old.client.Disconnect(ctx); err != nil {
+		slog.Warn("Disconnecting replaced MongoDB client failed", "error", err)
+	}
+}
+
+// Ping checks that the primary answers, for readiness probes.
+func Ping(ctx context.Context) error {
+	c := current.Load()
+	if c == nil {
+		return errors.New("not connected")
+	}
+	return c.client.Ping(ctx, readpref.Primary())
+}
+
+// Disconnect closes the current client, waiting for connections in use until
+// ctx is done.
+func Disconnect(ctx context.Context) error {
+	if c := current.Load(); c != nil {
+		return c.client.Disconnect(ctx)
+	}
+	return nil
+}
+
+// clientOptions applies the URI and the metrics and tracing monitors, replacing the user it names with
+// cfg.Username and cfg.Password when those are set.
+func clientOptions(cfg config.Mongo) *options.ClientOptions {
+	opts := options.Client().ApplyURI(cfg.URI).
+		SetMonitor(commandMonitors(metrics.CommandMonitor(), tracing.CommandMonitor())).
+		SetPoolMonitor(metrics.PoolMonitor())
+	if cfg.Username != "" {
+		credential := options.Credential{Username: cfg.Username, Password: cfg.Password}
+		if opts.Auth != nil {
+			credential.AuthSource = opts.Auth.AuthSource
+			credential.AuthMechanism = opts.Auth.AuthMechanism
+		}
+		opts.SetAuth(credential)
+	}
+	return opts
+}
+
+// commandMonitors passes the command events of the driver, which takes a
+// single monitor, to each of monitors.
+func commandMonitors(monitors ...*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				m.Started(ctx, evt)
+			}
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				m.Succeeded(ctx, evt)
+			}
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				m.Failed(ctx, evt)
+			}
+		},
+	}
+}
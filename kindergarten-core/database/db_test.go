@@ -0,0 +1,239 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"kindergarten-core/config"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// unreachable is where test clients point; nothing listens there, and the
+// tests replace ping, so no test waits on it.
+const unreachable = "mongodb://127.0.0.1:1"
+
+var testConfig = config.Mongo{
+	URI:            unreachable,
+	Database:       "kindergarten",
+	ConnectTimeout: time.Second,
+	RetryInitial:   10 * time.Millisecond,
+	RetryMax:       20 * time.Millisecond,
+	StartupBudget:  time.Second,
+}
+
+// fakePing makes ping fail the first failures calls, or all of them for -1,
+// and records when each call was made.
+func fakePing(t *testing.T, failures int) *[]time.Time {
+	t.Helper()
+	calls := &[]time.Time{}
+	saved := ping
+	t.Cleanup(func() { ping = saved })
+	ping = func(ctx context.Context, _ *mongo.Client) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("ping without a deadline")
+		}
+		*calls = append(*calls, time.Now())
+		if failures < 0 || len(*calls) <= failures {
+			return errors.New("server selection timeout")
+		}
+		return nil
+	}
+	return calls
+}
+
+// useConnection makes c current for the test and disconnects whatever the
+// test left current.
+func useConnection(t *testing.T, c *connection) {
+	t.Helper()
+	saved := current.Swap(c)
+	t.Cleanup(func() {
+		if c := current.Swap(saved); c != nil {
+			c.client.Disconnect(context.Background())
+		}
+	})
+}
+
+func newConnection(t *testing.T) *connection {
+	t.Helper()
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(unreachable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return &connection{client: client, database: client.Database("kindergarten")}
+}
+
+func isDisconnected(client *mongo.Client) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	return errors.Is(client.Ping(ctx, nil), mongo.ErrClientDisconnected)
+}
+
+// waitDisconnected waits up to a second for client to be disconnected.
+func waitDisconnected(client *mongo.Client) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if isDisconnected(client) {
+			return true
+		}
+	}
+	return false
+}
+
+func shortenDrain(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	savedTimeout, savedPoll := drainTimeout, drainPoll
+	t.Cleanup(func() { drainTimeout, drainPoll = savedTimeout, savedPoll })
+	drainTimeout, drainPoll = timeout, time.Millisecond
+}
+
+func TestNotConnected(t *testing.T) {
+	useConnection(t, nil)
+	if Client() == nil || Database() == nil {
+		t.Fatal("nil client or database before Connect")
+	}
+	_, err := Collection(context.Background(), "students").InsertOne(context.Background(), bson.M{"roll": "7"})
+	if !errors.Is(err, mongo.ErrClientDisconnected) {
+		t.Errorf("insert before Connect: %v, want %v", err, mongo.ErrClientDisconnected)
+	}
+	if err := Ping(context.Background()); err == nil {
+		t.Error("Ping before Connect succeeded")
+	}
+	if err := Disconnect(context.Background()); err != nil {
+		t.Errorf("Disconnect before Connect: %v", err)
+	}
+}
+
+func TestConnectBacksOff(t *testing.T) {
+	useConnection(t, nil)
+	calls := fakePing(t, 3)
+	if err := Connect(context.Background(), testConfig); err != nil {
+		t.Fatal(err)
+	}
+	if len(*calls) != 4 {
+		t.Fatalf("%d attempts, want 4", len(*calls))
+	}
+	// jitter waits between half the backoff and all of it
+	for i, backoff := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond} {
+		if wait := (*calls)[i+1].Sub((*calls)[i]); wait < backoff/2 || wait > backoff+50*time.Millisecond {
+			t.Errorf("wait %d = %v, want between %v and %v", i+1, wait, backoff/2, backoff)
+		}
+	}
+	if c := current.Load(); c == nil || c.database.Name() != "kindergarten" {
+		t.Errorf("connection = %v, want one to kindergarten", c)
+	}
+}
+
+func TestConnectBudget(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		degraded bool
+		wantErr  bool
+	}{
+		{"fails once spent", context.Background(), false, true},
+		{"starts degraded", context.Background(), true, false},
+		{"cancelled fails even degraded", cancelled, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConnection(t, nil)
+			calls := fakePing(t, -1)
+			cfg := testConfig
+			cfg.StartupBudget = 100 * time.Millisecond
+			cfg.DegradedStart = tt.degraded
+
+			start := time.Now()
+			err := Connect(tt.ctx, cfg)
+			if elapsed := time.Since(start); elapsed > cfg.StartupBudget+50*time.Millisecond {
+				t.Errorf("gave up after %v, want within the %v budget", elapsed, cfg.StartupBudget)
+			}
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "MongoDB unreachable after") {
+					t.Errorf("error = %v, want unreachable", err)
+				}
+				if current.Load() != nil {
+					t.Error("connection kept after a failed Connect")
+				}
+			} else {
+				if err != nil {
+					t.Errorf("error = %v, want a degraded start", err)
+				}
+				if current.Load() == nil {
+					t.Error("no connection kept for a degraded start")
+				}
+			}
+			if tt.ctx == context.Background() && len(*calls) < 3 {
+				t.Errorf("%d attempts, want the budget used", len(*calls))
+			}
+		})
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	shortenDrain(t, time.Minute)
+	old := newConnection(t)
+	useConnection(t, old)
+
+	fakePing(t, 1)
+	if err := Reconnect(testConfig); err == nil {
+		t.Fatal("Reconnect succeeded though the ping failed")
+	}
+	if current.Load() != old || isDisconnected(old.client) {
+		t.Fatal("failed Reconnect replaced the connection")
+	}
+
+	pinned, release := Pin(context.Background())
+	if err := Reconnect(testConfig); err != nil {
+		t.Fatal(err)
+	}
+	if current.Load() == old {
+		t.Fatal("Reconnect kept the old connection")
+	}
+	if got := Collection(pinned, "students").Database().Client(); got != old.client {
+		t.Error("pinned request moved to the new client")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if isDisconnected(old.client) {
+		t.Fatal("old client disconnected while pinned")
+	}
+	release()
+	if !waitDisconnected(old.client) {
+		t.Error("old client still connected after the pinned request ended")
+	}
+}
+
+func TestRetireGivesUpWaiting(t *testing.T) {
+	shortenDrain(t, 30*time.Millisecond)
+	old := newConnection(t)
+	old.users.Add(1)
+
+	start := time.Now()
+	retire(old)
+	if elapsed := time.Since(start); elapsed < drainTimeout {
+		t.Errorf("retired after %v, want the %v drain timeout", elapsed, drainTimeout)
+	}
+	if !isDisconnected(old.client) {
+		t.Error("old client still connected after the drain timeout")
+	}
+}
+
+func TestPinSkipsReplacedConnection(t *testing.T) {
+	c := newConnection(t)
+	useConnection(t, c)
+	ctx, release := Pin(context.Background())
+	if c.users.Load() != 1 || Collection(ctx, "students").Database().Client() != c.client {
+		t.Fatalf("users = %d, want the request counted on its connection", c.users.Load())
+	}
+	release()
+	release()
+	if c.users.Load() != 0 {
+		t.Errorf("users = %d after release, want 0", c.users.Load())
+	}
+}
@@ -0,0 +1,71 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"kindergarten-core/recorder"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RequestIDHeader carries the ID of a request from the caller, or to it when
+// the service made one up.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds IDs taken from callers so they cannot flood the log.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// RequestID returns the ID of the request ctx belongs to, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Middleware gives each request handled by handler an ID, taken from its
+// X-Request-ID header or generated, echoes it in the response and, unless
+// access logging is off, logs the request once it is done. route is the
+// path pattern the request matched.
+func Middleware(handler http.HandlerFunc, route string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSpace(r.Header.Get(RequestIDHeader))
+		if id == "" || len(id) > maxRequestIDLength {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+
+		start := time.Now()
+		rec := recorder.Wrap(w)
+		handler(rec, r.WithContext(ctx))
+		if !accessLog {
+			return
+		}
+
+		level := slog.LevelInfo
+		if rec.Status() >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(ctx, level, r.Method+" "+r.URL.Path,
+			slog.String("http.request.method", r.Method),
+			slog.String("url.path", r.URL.Path),
+			slog.String("url.query", r.URL.RawQuery),
+			slog.String("http.route", route),
+			slog.Int("http.response.status_code", rec.Status()),
+			slog.Int64("http.response.body.bytes", rec.Bytes()),
+			slog.Int64("event.duration", time.Since(start).Nanoseconds()),
+			slog.String("client.address", r.RemoteAddr),
+			slog.String("user_agent.original", r.UserAgent()),
+		)
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,98 @@
+// Package logging sets up the structured log of a service: JSON or text lines
+// from log/slog on stderr, each carrying the request ID and the trace,
+// transaction and span IDs of its context so Kibana can correlate it with the
+// APM trace. Lines written with the log package go through it too.
+package logging
+
+import (
+	"context"
+	"kindergarten-core/config"
+	"kindergarten-core/tracing"
+	"log/slog"
+	"os"
+)
+
+// accessLog is whether Middleware logs every request.
+var accessLog = true
+
+// Setup makes the logger described by cfg the default, labelled with the
+// service name.
+func Setup(service string, cfg config.Log) {
+	opts := &slog.HandlerOptions{Level: level(cfg.Level)}
+	var handler slog.Handler
+	if cfg.Format == "text" {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	} else {
+		opts.ReplaceAttr = ecsNames
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}).With("service.name", service))
+	accessLog = cfg.Access
+}
+
+func level(name string) slog.Level {
+	switch name {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// ecsNames renames the built-in JSON fields to their Elastic Common Schema
+// names, which Kibana shows without further mapping.
+func ecsNames(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.TimeKey:
+		a.Key = "@timestamp"
+	case slog.LevelKey:
+		a.Key = "log.level"
+	case slog.MessageKey:
+		a.Key = "message"
+	}
+	return a
+}
+
+// Fatal logs msg with err at error level and exits.
+func Fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// contextHandler adds the IDs found in the context of each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if id := RequestID(ctx); id != "" {
+			r.AddAttrs(slog.String("http.request.id", id))
+		}
+		ids := tracing.IDsOf(ctx)
+		if ids.Trace != "" {
+			r.AddAttrs(slog.String("trace.id", ids.Trace))
+		}
+		if ids.Transaction != "" {
+			r.AddAttrs(slog.String("transaction.id", ids.Transaction))
+		}
+		if ids.Span != "" {
+			r.AddAttrs(slog.String("span.id", ids.Span))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
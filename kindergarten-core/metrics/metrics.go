@@ -0,0 +1,67 @@
+// Package metrics exposes Prometheus metrics on /metrics: HTTP requests per
+// route, MongoDB commands and connection pool state, and record counts.
+package metrics
+
+import (
+	"kindergarten-core/recorder"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	httpPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_panics_total",
+		Help: "Handler panics recovered, by route.",
+	}, []string{"route"})
+
+	auditFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "audit_failures_total",
+		Help: "Changes saved without an audit log entry, by entity and action.",
+	}, []string{"entity", "action"})
+)
+
+// Register adds /metrics to http.DefaultServeMux. Like the probes it is not
+// prefixed, so the scraper finds it in the same place on every service.
+func Register() {
+	http.Handle("/metrics", promhttp.Handler())
+}
+
+// Middleware counts and times the requests handled by handler under route,
+// the path pattern rather than the request path so the labels stay bounded.
+func Middleware(handler http.HandlerFunc, route string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := recorder.Wrap(w)
+		handler(rec, r)
+
+		status := strconv.Itoa(rec.Status())
+		httpRequests.WithLabelValues(route, r.Method, status).Inc()
+		httpDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Panic counts a panic recovered from a handler of route.
+func Panic(route string) {
+	httpPanics.WithLabelValues(route).Inc()
+}
+
+// AuditFailure counts a change whose audit log entry could not be written.
+func AuditFailure(entity, action string) {
+	auditFailures.WithLabelValues(entity, action).Inc()
+}
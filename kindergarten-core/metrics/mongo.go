@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+var (
+	mongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mongo_command_duration_seconds",
+		Help:    "MongoDB command latency by collection and command.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"collection", "command"})
+
+	mongoErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mongo_command_errors_total",
+		Help: "Failed MongoDB commands by collection and command.",
+	}, []string{"collection", "command"})
+
+	mongoConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mongo_pool_connections",
+		Help: "MongoDB connections by state: open, or in_use by an operation.",
+	}, []string{"state"})
+
+	mongoCheckoutFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "mongo_pool_checkout_failures_total",
+		Help: "Operations that could not get a MongoDB connection from the pool.",
+	})
+)
+
+// commandMonitor remembers the collection of each running command; the
+// finished events only carry the request ID.
+type commandMonitor struct {
+	collections sync.Map // request ID -> collection
+}
+
+// CommandMonitor times MongoDB commands and counts their failures. Commands
+// that are not about a collection, such as ping, have an empty collection.
+func CommandMonitor() *event.CommandMonitor {
+	m := &commandMonitor{}
+	return &event.CommandMonitor{
+		Started: m.started,
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			collection := m.finish(evt.RequestID)
+			mongoDuration.WithLabelValues(collection, evt.CommandName).Observe(evt.Duration.Seconds())
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			collection := m.finish(evt.RequestID)
+			mongoDuration.WithLabelValues(collection, evt.CommandName).Observe(evt.Duration.Seconds())
+			mongoErrors.WithLabelValues(collection, evt.CommandName).Inc()
+		},
+	}
+}
+
+func (m *commandMonitor) started(_ context.Context, evt *event.CommandStartedEvent) {
+	// find, insert, aggregate, ... name the collection as the command's value;
+	// getMore names it in a field of its own
+	collection, ok := evt.Command.Lookup(evt.CommandName).StringValueOK()
+	if !ok {
+		collection, _ = evt.Command.Lookup("collection").StringValueOK()
+	}
+	m.collections.Store(evt.RequestID, collection)
+}
+
+func (m *commandMonitor) finish(requestID int64) string {
+	collection, _ := m.collections.LoadAndDelete(requestID)
+	s, _ := collection.(string)
+	return s
+}
+
+// PoolMonitor tracks the connections of the MongoDB pool. Clients replaced
+// by database.Reconnect report their connections closing, so the gauges stay
+// right across rotations.
+func PoolMonitor() *event.PoolMonitor {
+	open, inUse := mongoConnections.WithLabelValues("open"), mongoConnections.WithLabelValues("in_use")
+	return &event.PoolMonitor{
+		Event: func(evt *event.PoolEvent) {
+			switch evt.Type {
+			case event.ConnectionCreated:
+				open.Inc()
+			case event.ConnectionClosed:
+				open.Dec()
+			case event.GetSucceeded:
+				inUse.Inc()
+			case event.ConnectionReturned:
+				inUse.Dec()
+			case event.GetFailed:
+				mongoCheckoutFailures.Inc()
+			}
+		},
+	}
+}
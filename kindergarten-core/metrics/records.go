@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// countTimeout bounds counting the records of one collection during a scrape.
+const countTimeout = 2 * time.Second
+
+var recordsDesc = prometheus.NewDesc(
+	"kindergarten_records",
+	"Records by collection, excluding the trash.",
+	[]string{"collection"}, nil,
+)
+
+// recordCounter counts every collection whenever Prometheus scrapes, so the
+// gauge is never staler than the scrape interval and costs nothing in between.
+type recordCounter struct {
+	mu     sync.Mutex
+	counts map[string]func(ctx context.Context) (int64, error)
+}
+
+var records = &recordCounter{counts: map[string]func(ctx context.Context) (int64, error){}}
+
+func init() {
+	prometheus.MustRegister(records)
+}
+
+// Records exposes the number of records in collection, e.g. the total number
+// of students, as counted by count.
+func Records(collection string, count func(ctx context.Context) (int64, error)) {
+	records.mu.Lock()
+	defer records.mu.Unlock()
+	records.counts[collection] = count
+}
+
+func (c *recordCounter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- recordsDesc
+}
+
+func (c *recordCounter) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for collection, count := range c.counts {
+		ctx, cancel := context.WithTimeout(context.Background(), countTimeout)
+		n, err := count(ctx)
+		cancel()
+		if err != nil {
+			// leave the gauge out rather than report a wrong number
+			slog.Warn("Counting records for metrics failed", "collection", collection, "error", err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(recordsDesc, prometheus.GaugeValue, float64(n), collection)
+	}
+}
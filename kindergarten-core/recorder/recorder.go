@@ -0,0 +1,60 @@
+// Package recorder remembers the status and size of HTTP responses for the
+// middlewares that log, trace and measure requests.
+package recorder
+
+import "net/http"
+
+// Response is an http.ResponseWriter that records what was written through it.
+type Response struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+// Wrap returns w as a Response. When w already is one it is returned as is,
+// so nested middlewares share a single recorder.
+func Wrap(w http.ResponseWriter) *Response {
+	if rec, ok := w.(*Response); ok {
+		return rec
+	}
+	return &Response{ResponseWriter: w}
+}
+
+// Status is the status code of the response, 200 if the handler wrote none.
+func (rec *Response) Status() int {
+	if rec.status == 0 {
+		return http.StatusOK
+	}
+	return rec.status
+}
+
+// Bytes is the size of the body written so far.
+func (rec *Response) Bytes() int64 {
+	return rec.bytes
+}
+
+// Written reports whether the status line has been sent.
+func (rec *Response) Written() bool {
+	return rec.wroteHeader
+}
+
+func (rec *Response) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *Response) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *Response) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,161 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kindergarten-core/config"
+	"log/slog"
+	"net/http"
+	"path"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// Rotation timing; tests shorten it.
+var (
+	// minRotateInterval spaces rotations out when leases end as soon as they
+	// are handed out, as non-renewable or very short ones do.
+	minRotateInterval = 30 * time.Second
+	rotateBackoff     = time.Second
+	maxRotateBackoff  = time.Minute
+)
+
+// mongoCredentials reads a short-lived MongoDB user from the database secrets
+// engine and sets it in cfg.
+func (v *Vault) mongoCredentials(ctx context.Context, cfg *config.Mongo) (*vault.Secret, error) {
+	credsPath := path.Join(v.cfg.DatabaseMount, "creds", v.cfg.MongoRole)
+	secret, err := v.client.Logical().ReadWithContext(ctx, credsPath)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("no credentials at %s", credsPath)
+	}
+	username, _ := secret.Data["username"].(string)
+	password, _ := secret.Data["password"].(string)
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("credentials at %s have no username or password", credsPath)
+	}
+	cfg.Username, cfg.Password = username, password
+	slog.Info("Got MongoDB user from Vault", "user", username, "lease", (time.Duration(secret.LeaseDuration) * time.Second).String())
+	return secret, nil
+}
+
+// RenewMongoCredentials keeps the lease on the MongoDB user from Apply and
+// the Vault token it was read with renewed until ctx is done. Once Vault
+// stops renewing the lease, because it reached its max TTL or renewal keeps
+// failing, it reads a new user and calls reconnect with cfg changed to that
+// user, retrying with backoff until reconnect succeeds. Rotations are at
+// least minRotateInterval apart. Vault revokes the leases of a token when the
+// token expires, so once the token can no longer be renewed it logs in again
+// before it runs out and rotates the user as well; it also logs in again when
+// Vault refuses the token while rotating. It returns at once when v is nil or
+// the credentials are not from Vault.
+func (v *Vault) RenewMongoCredentials(ctx context.Context, cfg config.Mongo, reconnect func(config.Mongo) error) {
+	if v == nil || v.mongoLease == nil {
+		return
+	}
+	lease := v.mongoLease
+	rotated := time.Now()
+	for {
+		tokenDone, err := v.watch(ctx, lease)
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case tokenDone:
+			slog.Info("Vault token reached its max TTL, logging in again and rotating MongoDB credentials", "error", err)
+			if err := v.login(ctx); err != nil {
+				slog.Error("Vault login failed", "auth_method", v.cfg.AuthMethod, "error", err)
+			}
+		case err != nil:
+			slog.Warn("Renewing MongoDB credentials failed, rotating", "error", err)
+		default:
+			slog.Info("MongoDB credentials lease reached its max TTL, rotating")
+		}
+
+		if wait := time.Until(rotated.Add(minRotateInterval)); wait > 0 {
+			slog.Warn("MongoDB credentials lease ended right after rotating, waiting before the next rotation", "wait", wait.Round(time.Millisecond).String())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		backoff := rotateBackoff
+		for {
+			next, err := v.mongoCredentials(ctx, &cfg)
+			tokenRefused := isTokenRefused(err)
+			if err == nil {
+				if err = reconnect(cfg); err == nil {
+					lease = next
+					break
+				}
+			}
+			slog.Error("Rotating MongoDB credentials failed", "retry_in", backoff.String(), "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, maxRotateBackoff)
+			// a failed reconnect or an unreachable Vault needs no new token
+			if tokenRefused {
+				if err := v.login(ctx); err != nil {
+					slog.Error("Vault login failed", "auth_method", v.cfg.AuthMethod, "error", err)
+				}
+			}
+		}
+		rotated = time.Now()
+	}
+}
+
+// isTokenRefused reports whether Vault turned a request down because of the
+// token, as it does once the token has expired or been revoked.
+func isTokenRefused(err error) bool {
+	var respErr *vault.ResponseError
+	return errors.As(err, &respErr) && (respErr.StatusCode == http.StatusForbidden || respErr.StatusCode == http.StatusUnauthorized)
+}
+
+// watch renews lease and the Vault token until Vault will not renew one of
+// them any more or ctx is done. tokenDone tells which of them it was.
+func (v *Vault) watch(ctx context.Context, lease *vault.Secret) (tokenDone bool, err error) {
+	leaseWatcher, err := v.client.NewLifetimeWatcher(&vault.LifetimeWatcherInput{Secret: lease})
+	if err != nil {
+		return false, err
+	}
+	go leaseWatcher.Start()
+	defer leaseWatcher.Stop()
+
+	// a nil channel never delivers, so without a login token only the lease
+	// is watched
+	var tokenDoneCh <-chan error
+	var tokenRenewCh <-chan *vault.RenewOutput
+	if v.token != nil {
+		tokenWatcher, err := v.client.NewLifetimeWatcher(&vault.LifetimeWatcherInput{Secret: v.token})
+		if err != nil {
+			return false, err
+		}
+		go tokenWatcher.Start()
+		defer tokenWatcher.Stop()
+		tokenDoneCh, tokenRenewCh = tokenWatcher.DoneCh(), tokenWatcher.RenewCh()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case err := <-leaseWatcher.DoneCh():
+			return false, err
+		case err := <-tokenDoneCh:
+			return true, err
+		case renewal := <-leaseWatcher.RenewCh():
+			slog.Info("Renewed MongoDB credentials lease", "lease", (time.Duration(renewal.Secret.LeaseDuration) * time.Second).String())
+		case renewal := <-tokenRenewCh:
+			slog.Info("Renewed Vault token", "ttl", (time.Duration(renewal.Secret.Auth.LeaseDuration) * time.Second).String())
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"kindergarten-core/config"
+	"sync"
+	"testing"
+	"time"
+)
+
+func shortenRotation(t *testing.T, interval time.Duration) {
+	t.Helper()
+	savedInterval, savedBackoff, savedMax := minRotateInterval, rotateBackoff, maxRotateBackoff
+	t.Cleanup(func() { minRotateInterval, rotateBackoff, maxRotateBackoff = savedInterval, savedBackoff, savedMax })
+	minRotateInterval, rotateBackoff, maxRotateBackoff = interval, time.Millisecond, 4*time.Millisecond
+}
+
+// applyDynamic logs in to fv with approle and reads a MongoDB user whose
+// lease ends at once.
+func applyDynamic(t *testing.T, fv *fakeVault) (*Vault, config.Mongo) {
+	t.Helper()
+	fv.kv["mongodb"] = map[string]string{"uri": "mongodb://mongo:27017"}
+	fv.shortLeases = true
+	cfg := fv.config()
+	cfg.Vault.AuthMethod = "approle"
+	cfg.Vault.RoleID, cfg.Vault.SecretID = "role-1", "secret-1"
+	cfg.Vault.MongoRole = "students"
+	v, err := Apply(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, cfg.Mongo
+}
+
+// reconnects records the calls of a reconnect function that fails the first
+// failures times and cancels the test's context after stopAfter successes.
+type reconnects struct {
+	mu        sync.Mutex
+	calls     []config.Mongo
+	failures  int
+	stopAfter int
+	cancel    context.CancelFunc
+}
+
+func (r *reconnects) reconnect(cfg config.Mongo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, cfg)
+	if len(r.calls) <= r.failures {
+		return errors.New("auth failed")
+	}
+	if r.stopAfter > 0 && len(r.calls)-r.failures >= r.stopAfter {
+		r.cancel()
+	}
+	return nil
+}
+
+func (r *reconnects) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.calls)
+}
+
+func TestRenewSpacesRotationsOut(t *testing.T) {
+	shortenRotation(t, 50*time.Millisecond)
+	v, cfg := applyDynamic(t, newFakeVault(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 320*time.Millisecond)
+	defer cancel()
+	r := &reconnects{cancel: cancel}
+	v.RenewMongoCredentials(ctx, cfg, r.reconnect)
+
+	// a lease that ends at once would otherwise rotate in a tight loop
+	if n := r.count(); n < 3 || n > 7 {
+		t.Errorf("%d rotations in 320ms, want one every 50ms", n)
+	}
+	for _, got := range r.calls {
+		if got.Username != "v-students-abc" || got.Password != "generated" || got.URI != "mongodb://mongo:27017" {
+			t.Errorf("reconnected with %+v, want the new user", got)
+		}
+	}
+}
+
+func TestRenewRetriesReconnect(t *testing.T) {
+	shortenRotation(t, 0)
+	fv := newFakeVault(t)
+	v, cfg := applyDynamic(t, fv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r := &reconnects{failures: 3, stopAfter: 1, cancel: cancel}
+	v.RenewMongoCredentials(ctx, cfg, r.reconnect)
+
+	if r.count() != 4 {
+		t.Errorf("%d reconnects, want 3 failures and a success", r.count())
+	}
+	if reads := fv.credsRead(); reads != 1+4 {
+		t.Errorf("%d credential reads, want one per attempt after the first", reads-1)
+	}
+	// MongoDB refusing the user says nothing about the Vault token
+	if logins := fv.loginsTo("approle"); len(logins) != 1 {
+		t.Errorf("%d logins, want none after Apply's", len(logins)-1)
+	}
+}
+
+func TestRenewLogsInWhenTokenRefused(t *testing.T) {
+	shortenRotation(t, 0)
+	fv := newFakeVault(t)
+	v, cfg := applyDynamic(t, fv)
+	fv.set(func(fv *fakeVault) { fv.expired = true })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r := &reconnects{stopAfter: 1, cancel: cancel}
+	v.RenewMongoCredentials(ctx, cfg, r.reconnect)
+
+	if r.count() != 1 {
+		t.Errorf("%d reconnects, want one once logged in again", r.count())
+	}
+	if logins := fv.loginsTo("approle"); len(logins) != 2 {
+		t.Errorf("%d logins, want one after the token was refused", len(logins)-1)
+	}
+}
+
+func TestRenewKeepsTokenWhileVaultIsDown(t *testing.T) {
+	t.Setenv("VAULT_MAX_RETRIES", "0")
+	shortenRotation(t, 0)
+	fv := newFakeVault(t)
+	v, cfg := applyDynamic(t, fv)
+	fv.set(func(fv *fakeVault) { fv.down = true })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r := &reconnects{cancel: cancel}
+	v.RenewMongoCredentials(ctx, cfg, r.reconnect)
+
+	if r.count() != 0 {
+		t.Errorf("%d reconnects without credentials", r.count())
+	}
+	if logins := fv.loginsTo("approle"); len(logins) != 1 {
+		t.Errorf("%d logins while Vault was down, want none", len(logins)-1)
+	}
+}
+
+func TestRenewWithoutLease(t *testing.T) {
+	reconnect := func(config.Mongo) error {
+		t.Error("reconnected without a lease")
+		return nil
+	}
+	var v *Vault
+	v.RenewMongoCredentials(context.Background(), config.Mongo{}, reconnect)
+	(&Vault{}).RenewMongoCredentials(context.Background(), config.Mongo{}, reconnect)
+}
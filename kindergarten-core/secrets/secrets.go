@@ -0,0 +1,73 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"kindergarten-core/config"
+	"log/slog"
+)
+
+// Apply fills the secret settings of cfg from Vault when vault.address is
+// set. Vault values win; anything Vault does not have, or everything when
+// Vault cannot be reached, keeps the value from the environment. It fails
+// only if the MongoDB URI ends up missing.
+//
+// With vault.mongo_role set, the MongoDB user is a short-lived one from the
+// database secrets engine; pass the returned Vault's RenewMongoCredentials
+// to a goroutine to keep it valid. The Vault is nil when Vault is not used.
+func Apply(ctx context.Context, cfg *config.Config) (*Vault, error) {
+	if cfg.Vault.Address == "" {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Vault.Timeout)
+	defer cancel()
+	v, err := fromVault(ctx, cfg)
+	if err != nil {
+		if cfg.Mongo.URI == "" {
+			return nil, errors.Join(errors.New("vault: MongoDB URI unavailable"), err)
+		}
+		slog.Warn("Vault unavailable, using secrets from the environment", "error", err)
+		return nil, nil
+	}
+	if cfg.Mongo.URI == "" {
+		return nil, errors.New("MongoDB URI is neither in Vault (" + cfg.Vault.KVMount + "/" + cfg.Vault.MongoPath + ", key uri) nor in MONGODB_URI")
+	}
+	return v, nil
+}
+
+func fromVault(ctx context.Context, cfg *config.Config) (*Vault, error) {
+	v, err := Login(ctx, cfg.Vault)
+	if err != nil {
+		return nil, err
+	}
+
+	mongo, err := v.KV(ctx, cfg.Vault.MongoPath)
+	if err != nil {
+		return nil, err
+	}
+	apm, err := v.KV(ctx, cfg.Vault.APMPath)
+	if err != nil {
+		return nil, err
+	}
+
+	found := 0
+	set := func(setting *string, value string) {
+		if value != "" {
+			*setting = value
+			found++
+		}
+	}
+	set(&cfg.Mongo.URI, mongo["uri"])
+	set(&cfg.Mongo.Database, mongo["database"])
+	set(&cfg.APM.ServerURL, apm["server_url"])
+	set(&cfg.APM.SecretToken, apm["secret_token"])
+	slog.Info("Read settings from Vault", "count", found, "address", cfg.Vault.Address)
+
+	if cfg.Vault.MongoRole != "" {
+		if v.mongoLease, err = v.mongoCredentials(ctx, &cfg.Mongo); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
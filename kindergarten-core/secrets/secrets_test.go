@@ -0,0 +1,248 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"kindergarten-core/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const clientToken = "s.login-token"
+
+// fakeVault serves the parts of the Vault API the service uses: the
+// kubernetes and approle logins, KV v2 reads and database credentials.
+type fakeVault struct {
+	*httptest.Server
+	mu sync.Mutex
+	// kv holds the KV v2 secrets by path under the mount
+	kv map[string]map[string]string
+	// logins holds the body of every login by mount
+	logins map[string][]map[string]string
+	down   bool
+	// creds counts the reads of database credentials
+	creds int
+	// shortLeases hands out credentials whose lease ends at once
+	shortLeases bool
+	// expired refuses the login token until the next login
+	expired bool
+}
+
+func newFakeVault(t *testing.T) *fakeVault {
+	fv := &fakeVault{kv: map[string]map[string]string{}, logins: map[string][]map[string]string{}}
+	fv.Server = httptest.NewServer(http.HandlerFunc(fv.serve))
+	t.Cleanup(fv.Close)
+	return fv
+}
+
+func (fv *fakeVault) serve(w http.ResponseWriter, r *http.Request) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	if fv.down {
+		http.Error(w, `{"errors":["sealed"]}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	reply := func(body any) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+	}
+	route := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if mount, ok := strings.CutSuffix(route, "/login"); ok && strings.HasPrefix(mount, "auth/") {
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+		mount = strings.TrimPrefix(mount, "auth/")
+		fv.logins[mount] = append(fv.logins[mount], body)
+		fv.expired = false
+		reply(map[string]any{"auth": map[string]any{"client_token": clientToken, "lease_duration": 3600, "renewable": true}})
+		return
+	}
+
+	if token := r.Header.Get("X-Vault-Token"); token != clientToken || fv.expired {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+	switch {
+	case strings.HasPrefix(route, "kindergarten/config/data/"):
+		data, ok := fv.kv[strings.TrimPrefix(route, "kindergarten/config/data/")]
+		if !ok {
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+			return
+		}
+		reply(map[string]any{"data": map[string]any{"data": data, "metadata": map[string]any{"version": 1}}})
+	case route == "database/creds/students":
+		fv.creds++
+		lease, renewable := 300, true
+		if fv.shortLeases {
+			lease, renewable = 0, false
+		}
+		reply(map[string]any{
+			"lease_id": "database/creds/students/abc", "lease_duration": lease, "renewable": renewable,
+			"data": map[string]string{"username": "v-students-abc", "password": "generated"},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// set changes fv while it may be serving.
+func (fv *fakeVault) set(change func(fv *fakeVault)) {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	change(fv)
+}
+
+func (fv *fakeVault) credsRead() int {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	return fv.creds
+}
+
+func (fv *fakeVault) loginsTo(mount string) []map[string]string {
+	fv.mu.Lock()
+	defer fv.mu.Unlock()
+	return fv.logins[mount]
+}
+
+// config is the default configuration pointed at fv.
+func (fv *fakeVault) config() *config.Config {
+	cfg := config.Defaults("Student Service", 8080)
+	cfg.Vault.Address = fv.URL
+	cfg.Vault.Timeout = 5 * time.Second
+	return &cfg
+}
+
+func serviceAccountToken(t *testing.T) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(file, []byte("service-account-jwt\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestApplyKubernetesLogin(t *testing.T) {
+	fv := newFakeVault(t)
+	fv.kv["mongodb"] = map[string]string{"uri": "mongodb://mongo:27017", "database": "registry"}
+	fv.kv["apm"] = map[string]string{"server_url": "http://apm:8200", "secret_token": "apm-token"}
+	cfg := fv.config()
+	cfg.Vault.KubernetesTokenPath = serviceAccountToken(t)
+
+	v, err := Apply(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v == nil {
+		t.Fatal("Apply returned no Vault")
+	}
+
+	logins := fv.loginsTo("kubernetes")
+	if len(logins) != 1 || logins[0]["role"] != "kindergarten-role" || logins[0]["jwt"] != "service-account-jwt" {
+		t.Errorf("kubernetes logins = %v, want one with the role and the trimmed service account token", logins)
+	}
+	if cfg.Mongo.URI != "mongodb://mongo:27017" || cfg.Mongo.Database != "registry" {
+		t.Errorf("mongo = %q, %q, want the values from Vault", cfg.Mongo.URI, cfg.Mongo.Database)
+	}
+	if cfg.APM.ServerURL != "http://apm:8200" || cfg.APM.SecretToken != "apm-token" {
+		t.Errorf("apm = %q, %q, want the values from Vault", cfg.APM.ServerURL, cfg.APM.SecretToken)
+	}
+	if v.mongoLease != nil {
+		t.Error("static credentials got a lease")
+	}
+}
+
+func TestApplyAppRoleLogin(t *testing.T) {
+	fv := newFakeVault(t)
+	fv.kv["mongodb"] = map[string]string{"uri": "mongodb://mongo:27017"}
+	cfg := fv.config()
+	cfg.Vault.AuthMethod = "approle"
+	cfg.Vault.RoleID, cfg.Vault.SecretID = "role-1", "secret-1"
+	cfg.Vault.MongoRole = "students"
+
+	v, err := Apply(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logins := fv.loginsTo("approle")
+	if len(logins) != 1 || logins[0]["role_id"] != "role-1" || logins[0]["secret_id"] != "secret-1" {
+		t.Errorf("approle logins = %v, want one with the role and secret ID", logins)
+	}
+	if v.token == nil {
+		t.Error("login token not kept for renewal")
+	}
+	if cfg.Mongo.Username != "v-students-abc" || cfg.Mongo.Password != "generated" {
+		t.Errorf("mongo user = %q, %q, want the one from the database secrets engine", cfg.Mongo.Username, cfg.Mongo.Password)
+	}
+	if v.mongoLease == nil || v.mongoLease.LeaseID != "database/creds/students/abc" {
+		t.Errorf("mongo lease = %+v, want the credentials lease", v.mongoLease)
+	}
+}
+
+func TestApplyKeepsEnvironmentValues(t *testing.T) {
+	fv := newFakeVault(t)
+	// no apm secret and no database in the mongodb one
+	fv.kv["mongodb"] = map[string]string{"uri": "mongodb://vault:27017"}
+	cfg := fv.config()
+	cfg.Vault.AuthMethod, cfg.Vault.Token = "token", clientToken
+	cfg.Mongo.Database = "from-env"
+	cfg.APM.ServerURL = "http://env-apm:8200"
+
+	if _, err := Apply(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Mongo.URI != "mongodb://vault:27017" {
+		t.Errorf("URI = %q, want the one from Vault", cfg.Mongo.URI)
+	}
+	if cfg.Mongo.Database != "from-env" || cfg.APM.ServerURL != "http://env-apm:8200" {
+		t.Errorf("database, APM server = %q, %q, want the environment values", cfg.Mongo.Database, cfg.APM.ServerURL)
+	}
+}
+
+func TestApplyVaultUnavailable(t *testing.T) {
+	// the client retries 5xx answers with backoff
+	t.Setenv("VAULT_MAX_RETRIES", "0")
+	fv := newFakeVault(t)
+	fv.down = true
+
+	cfg := fv.config()
+	cfg.Vault.KubernetesTokenPath = serviceAccountToken(t)
+	cfg.Mongo.URI = "mongodb://env:27017"
+	v, err := Apply(context.Background(), cfg)
+	if err != nil || v != nil {
+		t.Fatalf("Apply = %v, %v, want the environment used", v, err)
+	}
+	if cfg.Mongo.URI != "mongodb://env:27017" {
+		t.Errorf("URI = %q, want the environment value", cfg.Mongo.URI)
+	}
+
+	cfg = fv.config()
+	cfg.Vault.KubernetesTokenPath = serviceAccountToken(t)
+	if _, err := Apply(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "MongoDB URI unavailable") {
+		t.Errorf("error = %v, want the URI reported missing", err)
+	}
+}
+
+func TestApplyWithoutURI(t *testing.T) {
+	fv := newFakeVault(t)
+	cfg := fv.config()
+	cfg.Vault.AuthMethod, cfg.Vault.Token = "token", clientToken
+
+	if _, err := Apply(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "neither in Vault") {
+		t.Errorf("error = %v, want the URI reported missing", err)
+	}
+}
+
+func TestApplyWithoutVault(t *testing.T) {
+	cfg := config.Defaults("Student Service", 8080)
+	cfg.Mongo.URI = "mongodb://env:27017"
+	if v, err := Apply(context.Background(), &cfg); err != nil || v != nil {
+		t.Errorf("Apply = %v, %v, want nothing done", v, err)
+	}
+}
@@ -0,0 +1,108 @@
+// Package secrets fetches the settings that should not live in config files
+// or manifests, such as the MongoDB URI, from HashiCorp Vault.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kindergarten-core/config"
+	"os"
+	"path"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// Vault is a client logged in to Vault.
+type Vault struct {
+	client *vault.Client
+	cfg    config.Vault
+	// token is the login of the kubernetes and approle methods, nil for a
+	// fixed token
+	token *vault.Secret
+	// mongoLease is the lease of the MongoDB user, nil for static credentials
+	mongoLease *vault.Secret
+}
+
+// Login connects to Vault and authenticates with the configured method:
+// the pod's service account token for kubernetes, a role and secret ID for
+// approle, or a fixed token.
+func Login(ctx context.Context, cfg config.Vault) (*Vault, error) {
+	clientConfig := vault.DefaultConfig()
+	if clientConfig.Error != nil {
+		return nil, clientConfig.Error
+	}
+	clientConfig.Address = cfg.Address
+	clientConfig.Timeout = cfg.Timeout
+	if cfg.SkipVerify {
+		if err := clientConfig.ConfigureTLS(&vault.TLSConfig{Insecure: true}); err != nil {
+			return nil, err
+		}
+	}
+	client, err := vault.NewClient(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	// NewClient picks up VAULT_TOKEN by itself; only token auth may use it
+	client.ClearToken()
+
+	v := &Vault{client: client, cfg: cfg}
+	if err := v.login(ctx); err != nil {
+		return nil, fmt.Errorf("%s login: %w", cfg.AuthMethod, err)
+	}
+	return v, nil
+}
+
+func (v *Vault) login(ctx context.Context) error {
+	var mount string
+	var data map[string]interface{}
+	switch v.cfg.AuthMethod {
+	case "token":
+		v.client.SetToken(v.cfg.Token)
+		v.token = nil
+		return nil
+	case "kubernetes":
+		jwt, err := os.ReadFile(v.cfg.KubernetesTokenPath)
+		if err != nil {
+			return err
+		}
+		mount = v.cfg.KubernetesMount
+		data = map[string]interface{}{"role": v.cfg.Role, "jwt": strings.TrimSpace(string(jwt))}
+	case "approle":
+		mount = v.cfg.AppRoleMount
+		data = map[string]interface{}{"role_id": v.cfg.RoleID, "secret_id": v.cfg.SecretID}
+	default:
+		return fmt.Errorf("unknown auth method %q", v.cfg.AuthMethod)
+	}
+
+	secret, err := v.client.Logical().WriteWithContext(ctx, path.Join("auth", mount, "login"), data)
+	if err != nil {
+		return err
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return errors.New("no token in the login response")
+	}
+	v.client.SetToken(secret.Auth.ClientToken)
+	v.token = secret
+	return nil
+}
+
+// KV reads the string values of the KV v2 secret at name under the configured
+// mount. A secret that does not exist reads as empty.
+func (v *Vault) KV(ctx context.Context, name string) (map[string]string, error) {
+	secret, err := v.client.KVv2(v.cfg.KVMount).Get(ctx, name)
+	if errors.Is(err, vault.ErrSecretNotFound) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for key, value := range secret.Data {
+		if s, ok := value.(string); ok {
+			values[key] = s
+		}
+	}
+	return values, nil
+}
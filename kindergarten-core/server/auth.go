@@ -0,0 +1,44 @@
+package server
+
+import (
+	"errors"
+	"kindergarten-core/auth"
+	"kindergarten-core/crud"
+	"kindergarten-core/tracing"
+	"net/http"
+	"strconv"
+)
+
+// authenticate lets requests reach handler only with a valid bearer token
+// while authentication is on, and puts the principal of the token into their
+// context. The rest get a 401 problem response with a WWW-Authenticate
+// challenge saying what was wrong.
+func authenticate(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !auth.Enabled() {
+			handler(w, r)
+			return
+		}
+
+		principal, err := auth.Authenticate(r)
+		switch {
+		case errors.Is(err, auth.ErrKeysUnavailable):
+			crud.AuthUnavailable(w, r)
+			return
+		case errors.Is(err, auth.ErrNoToken):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kindergarten"`)
+			crud.Unauthorized(w, r, err.Error())
+			return
+		case err != nil:
+			w.Header().Set("WWW-Authenticate", `Bearer realm="kindergarten", error="invalid_token", error_description=`+strconv.Quote(err.Error()))
+			crud.Unauthorized(w, r, err.Error())
+			return
+		}
+
+		ctx := auth.NewContext(r.Context(), principal)
+		if principal.Tenant != "" {
+			tracing.SetLabel(ctx, "tenant", principal.Tenant)
+		}
+		handler(w, r.WithContext(ctx))
+	}
+}
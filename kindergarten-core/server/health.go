@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health serves the probes of a service:
+//
+//	/livez     the process is up; never checks dependencies
+//	/startupz  startup has finished, see Started
+//	/readyz    started, not shutting down, and every check passes
+//
+// /readyz answers with every check's status and latency, e.g.
+//
+//	{"status": "ready", "service": "Student Service", "checks": [
+//	  {"name": "mongo", "status": "ok", "latency_ms": 1.2}]}
+type Health struct {
+	service string
+	timeout time.Duration
+	started atomic.Bool
+	checks  []healthCheck
+}
+
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// CheckResult is the outcome of one readiness check.
+type CheckResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthReport is the body of the probe responses.
+type HealthReport struct {
+	Status  string        `json:"status"`
+	Service string        `json:"service"`
+	Checks  []CheckResult `json:"checks,omitempty"`
+}
+
+// NewHealth creates the probes of service; every check gets timeout.
+func NewHealth(service string, timeout time.Duration) *Health {
+	return &Health{service: service, timeout: timeout}
+}
+
+// Check adds a readiness check. check must give up when ctx is done.
+func (h *Health) Check(name string, check func(ctx context.Context) error) {
+	h.checks = append(h.checks, healthCheck{name: name, check: check})
+}
+
+// Started marks startup as finished.
+func (h *Health) Started() {
+	h.started.Store(true)
+}
+
+// Register adds the probe endpoints to http.DefaultServeMux. They are not
+// prefixed, so orchestrators find them in the same place on every service.
+func (h *Health) Register() {
+	http.HandleFunc("/livez", h.Live)
+	http.HandleFunc("/startupz", h.Startup)
+	http.HandleFunc("/readyz", h.Ready)
+	// /health predates the probes and answers like /readyz
+	http.HandleFunc("/health", h.Ready)
+}
+
+// Live answers 200 while the process can serve requests at all.
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	h.write(w, http.StatusOK, HealthReport{Status: "alive", Service: h.service})
+}
+
+// Startup answers 503 until Started is called.
+func (h *Health) Startup(w http.ResponseWriter, r *http.Request) {
+	if !h.started.Load() {
+		h.write(w, http.StatusServiceUnavailable, HealthReport{Status: "starting", Service: h.service})
+		return
+	}
+	h.write(w, http.StatusOK, HealthReport{Status: "started", Service: h.service})
+}
+
+// Ready runs every check concurrently and answers 200 only if all pass, the
+// service has started and it is not draining.
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	report := HealthReport{Status: "ready", Service: h.service, Checks: h.run(r.Context())}
+	for _, result := range report.Checks {
+		if result.Status == "fail" {
+			report.Status = "not_ready"
+		}
+	}
+	switch {
+	case Draining():
+		report.Status = "draining"
+	case !h.started.Load():
+		report.Status = "starting"
+	}
+
+	status := http.StatusOK
+	if report.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	h.write(w, status, report)
+}
+
+func (h *Health) run(ctx context.Context) []CheckResult {
+	results := make([]CheckResult, len(h.checks))
+	var wg sync.WaitGroup
+	for i, c := range h.checks {
+		wg.Add(1)
+		go func(i int, c healthCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.check(ctx)
+			results[i] = CheckResult{
+				Name:      c.name,
+				Status:    "ok",
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				results[i].Status = "fail"
+				results[i].Error = err.Error()
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	return results
+}
+
+func (h *Health) write(w http.ResponseWriter, status int, report HealthReport) {
+	EnableCors(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
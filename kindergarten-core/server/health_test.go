@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func passing(context.Context) error { return nil }
+
+func failing(context.Context) error { return errors.New("connection refused") }
+
+// hanging gives up only when its context is done.
+func hanging(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func probe(t *testing.T, handler http.HandlerFunc, path string) (int, HealthReport) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", path, nil))
+	var report HealthReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Header().Get("Cache-Control"); got != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", got)
+	}
+	return w.Code, report
+}
+
+func TestReady(t *testing.T) {
+	tests := []struct {
+		name       string
+		checks     map[string]func(context.Context) error
+		started    bool
+		draining   bool
+		wantCode   int
+		wantStatus string
+		wantChecks map[string]string
+	}{
+		{
+			name:       "ready",
+			checks:     map[string]func(context.Context) error{"mongo": passing, "indexes": passing},
+			started:    true,
+			wantCode:   http.StatusOK,
+			wantStatus: "ready",
+			wantChecks: map[string]string{"mongo": "ok", "indexes": "ok"},
+		},
+		{
+			name:       "a check fails",
+			checks:     map[string]func(context.Context) error{"mongo": passing, "indexes": failing},
+			started:    true,
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "not_ready",
+			wantChecks: map[string]string{"mongo": "ok", "indexes": "fail"},
+		},
+		{
+			name:       "starting",
+			checks:     map[string]func(context.Context) error{"mongo": passing},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "starting",
+			wantChecks: map[string]string{"mongo": "ok"},
+		},
+		{
+			name:       "draining wins",
+			checks:     map[string]func(context.Context) error{"mongo": failing},
+			draining:   true,
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "draining",
+			wantChecks: map[string]string{"mongo": "fail"},
+		},
+		{
+			name:       "check timed out",
+			checks:     map[string]func(context.Context) error{"mongo": hanging, "indexes": passing},
+			started:    true,
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "not_ready",
+			wantChecks: map[string]string{"mongo": "fail", "indexes": "ok"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHealth("Student Service", 50*time.Millisecond)
+			for _, name := range []string{"mongo", "indexes"} {
+				if check, ok := tt.checks[name]; ok {
+					h.Check(name, check)
+				}
+			}
+			if tt.started {
+				h.Started()
+			}
+			draining.Store(tt.draining)
+			defer draining.Store(false)
+
+			start := time.Now()
+			code, report := probe(t, h.Ready, "/readyz")
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("probe took %v, want the checks cut off after 50ms", elapsed)
+			}
+			if code != tt.wantCode || report.Status != tt.wantStatus || report.Service != "Student Service" {
+				t.Errorf("readyz = %d %+v, want %d %s", code, report, tt.wantCode, tt.wantStatus)
+			}
+			got := map[string]string{}
+			for _, c := range report.Checks {
+				got[c.Name] = c.Status
+				if (c.Status == "fail") != (c.Error != "") {
+					t.Errorf("check %s: status %s with error %q", c.Name, c.Status, c.Error)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.wantChecks) {
+				t.Errorf("checks = %v, want %v", got, tt.wantChecks)
+			}
+		})
+	}
+}
+
+func TestReadyTimeoutError(t *testing.T) {
+	h := NewHealth("Student Service", 10*time.Millisecond)
+	h.Check("mongo", hanging)
+	h.Started()
+	_, report := probe(t, h.Ready, "/readyz")
+	if len(report.Checks) != 1 || report.Checks[0].Error != context.DeadlineExceeded.Error() || report.Checks[0].LatencyMs < 10 {
+		t.Errorf("checks = %+v, want mongo cut off after 10ms", report.Checks)
+	}
+}
+
+func TestStartupAndLive(t *testing.T) {
+	h := NewHealth("Student Service", time.Second)
+	h.Check("mongo", failing)
+	if code, report := probe(t, h.Startup, "/startupz"); code != http.StatusServiceUnavailable || report.Status != "starting" {
+		t.Errorf("startupz before Started = %d %s, want 503 starting", code, report.Status)
+	}
+	h.Started()
+	if code, report := probe(t, h.Startup, "/startupz"); code != http.StatusOK || report.Status != "started" {
+		t.Errorf("startupz = %d %s, want 200 started", code, report.Status)
+	}
+	// liveness never runs the checks
+	if code, report := probe(t, h.Live, "/livez"); code != http.StatusOK || report.Status != "alive" || report.Checks != nil {
+		t.Errorf("livez = %d %+v, want 200 alive without checks", code, report)
+	}
+}
+
+var registerOnce sync.Once
+
+func TestRegister(t *testing.T) {
+	h := NewHealth("Student Service", time.Second)
+	h.Check("mongo", failing)
+	h.Started()
+	// the default mux refuses a pattern twice, as with go test -count
+	registerOnce.Do(h.Register)
+	for path, want := range map[string]int{
+		"/livez":    http.StatusOK,
+		"/startupz": http.StatusOK,
+		"/readyz":   http.StatusServiceUnavailable,
+		"/health":   http.StatusServiceUnavailable,
+	} {
+		code, report := probe(t, http.DefaultServeMux.ServeHTTP, path)
+		if code != want {
+			t.Errorf("%s = %d, want %d", path, code, want)
+		}
+		if (path == "/health" || path == "/readyz") && (report.Status != "not_ready" || len(report.Checks) != 1) {
+			t.Errorf("%s = %+v, want the readiness report", path, report)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kindergarten-core/auth"
+	"kindergarten-core/config"
+	"kindergarten-core/crud"
+	"kindergarten-core/database"
+	"kindergarten-core/logging"
+	"kindergarten-core/metrics"
+	"kindergarten-core/secrets"
+	"kindergarten-core/tracing"
+	"log/slog"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Resource is what Main serves, a *crud.Resource.
+type Resource interface {
+	Routes() []crud.Route
+	CollectionName() string
+	Count(ctx context.Context) (int64, error)
+	EnsureIndexes(ctx context.Context) error
+	StartTrashPurge(ctx context.Context, cfg config.Trash)
+}
+
+// indexRetryInterval is how often Main tries again to create indexes that
+// could not be created.
+const indexRetryInterval = time.Minute
+
+// Main runs a service for res: it loads the configuration over defaults,
+// reads the secrets from Vault, sets up logging, tracing and authentication
+// and serves the probes and metrics. Meanwhile it connects to MongoDB, then
+// serves the routes of res as well and reports itself started. It runs until
+// SIGINT or SIGTERM; any failure on the way is fatal. With mongo.migrate set
+// it only creates the indexes and exits.
+func Main(defaults config.Config, res Resource) {
+	cfg := config.MustLoad(defaults)
+	logging.Setup(cfg.Service.Name, cfg.Log)
+
+	// Shut down gracefully on SIGINT or SIGTERM, e.g. from a rolling deploy
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Secrets from Vault, if configured
+	vault, err := secrets.Apply(ctx, cfg)
+	if err != nil {
+		logging.Fatal("Loading secrets failed", err)
+	}
+
+	if cfg.Mongo.Migrate {
+		migrate(ctx, cfg.Mongo, res)
+		return
+	}
+
+	// Tracing to Elastic APM, an OTLP collector, both or neither
+	if err := tracing.Init(ctx, cfg.Service.Name, cfg.Tracing, cfg.APM); err != nil {
+		logging.Fatal("Tracing setup failed", err)
+	}
+
+	// Bearer token authentication, if an issuer or JWKS is configured
+	auth.Init(cfg.Auth)
+
+	// Probes and metrics are served from the start, so /startupz reports the
+	// service starting while it connects. /readyz checks MongoDB and, until
+	// they have been created, the indexes: without the unique index only the
+	// checks of add and import keep keys from being used twice.
+	health := NewHealth(cfg.Service.Name, cfg.Service.HealthTimeout)
+	health.Check("mongo", database.Ping)
+	indexes := &indexStatus{err: errors.New("not created yet")}
+	health.Check("indexes", indexes.check)
+	health.Register()
+	metrics.Register()
+
+	// startCtx is also cancelled when the server fails, e.g. because its port
+	// is taken, so startup gives up
+	startCtx, abort := context.WithCancel(ctx)
+	defer abort()
+	served := make(chan error, 1)
+	go func() {
+		served <- Run(ctx, cfg.Service)
+		abort()
+	}()
+	slog.Info("Service starting", "port", cfg.Service.Port)
+
+	if err := start(startCtx, cfg, vault, res, indexes); err != nil && startCtx.Err() == nil {
+		logging.Fatal("Startup failed", err)
+	} else if err == nil {
+		health.Started()
+		slog.Info("Service running", "port", cfg.Service.Port)
+	}
+
+	if err := <-served; err != nil {
+		logging.Fatal("Server failed", err)
+	}
+}
+
+// start connects to MongoDB and serves res once it is connected.
+func start(ctx context.Context, cfg *config.Config, vault *secrets.Vault, res Resource, indexes *indexStatus) error {
+	// Database connection
+	if err := database.Connect(ctx, cfg.Mongo); err != nil {
+		return fmt.Errorf("database connection: %w", err)
+	}
+	// Keep short-lived MongoDB credentials from Vault valid
+	go vault.RenewMongoCredentials(ctx, cfg.Mongo, database.Reconnect)
+
+	// Purge records that stayed in the trash past the retention
+	res.StartTrashPurge(ctx, cfg.Trash)
+
+	// Create the indexes now and keep retrying in the background if that fails
+	indexCtx, cancel := context.WithTimeout(ctx, cfg.Mongo.ConnectTimeout)
+	err := indexes.ensure(indexCtx, res)
+	cancel()
+	if err != nil {
+		go indexes.retry(ctx, res, cfg.Mongo.ConnectTimeout)
+	}
+
+	// Routes, traced and measured
+	Register(res.Routes(), Options{Prefix: cfg.Service.Prefix})
+
+	// The number of records on /metrics
+	metrics.Records(res.CollectionName(), res.Count)
+	return nil
+}
+
+// indexStatus is the outcome of the last attempt to create the indexes.
+type indexStatus struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (s *indexStatus) ensure(ctx context.Context, res Resource) error {
+	err := res.EnsureIndexes(ctx)
+	if err != nil {
+		slog.Warn("Creating indexes failed", "error", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	return err
+}
+
+// retry tries again every indexRetryInterval until the indexes exist or ctx
+// is done.
+func (s *indexStatus) retry(ctx context.Context, res Resource, timeout time.Duration) {
+	ticker := time.NewTicker(indexRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := s.ensure(attemptCtx, res)
+		cancel()
+		if err == nil {
+			slog.Info("Created indexes")
+			return
+		}
+	}
+}
+
+func (s *indexStatus) check(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// migrate creates the indexes of res and exits, listing the records that
+// block the unique index, if any. It is the step to run, e.g. as a Kubernetes
+// Job, after cleaning up such records.
+func migrate(ctx context.Context, cfg config.Mongo, res Resource) {
+	if err := database.Connect(ctx, cfg); err != nil {
+		logging.Fatal("Database connection failed", err)
+	}
+	defer database.Disconnect(context.Background())
+
+	var conflict *crud.IndexConflictError
+	switch err := res.EnsureIndexes(ctx); {
+	case errors.As(err, &conflict):
+		for _, c := range conflict.Conflicts {
+			slog.Error("Records share a key", "collection", conflict.Collection, "key", conflict.Key, "value", c.Key, "records", c.Records)
+		}
+		logging.Fatal("Migration failed; remove or rekey the records sharing a key and run it again", err)
+	case err != nil:
+		logging.Fatal("Migration failed", err)
+	}
+	slog.Info("Indexes created", "collection", res.CollectionName())
+}
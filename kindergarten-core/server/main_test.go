@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"kindergarten-core/config"
+	"kindergarten-core/crud"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// indexedResource is a Resource whose indexes fail to be created until
+// blocked is cleared.
+type indexedResource struct {
+	blocked error
+}
+
+func (r *indexedResource) Routes() []crud.Route                          { return nil }
+func (r *indexedResource) CollectionName() string                        { return "students" }
+func (r *indexedResource) Count(context.Context) (int64, error)          { return 0, nil }
+func (r *indexedResource) EnsureIndexes(context.Context) error           { return r.blocked }
+func (r *indexedResource) StartTrashPurge(context.Context, config.Trash) {}
+
+func TestIndexesGateReadiness(t *testing.T) {
+	res := &indexedResource{blocked: errors.New(`unique roll index on students blocked by records sharing a roll: "12" (2 records)`)}
+	indexes := &indexStatus{err: errors.New("not created yet")}
+	h := NewHealth("Student Service", time.Second)
+	h.Check("indexes", indexes.check)
+	h.Started()
+
+	if code, report := probe(t, h.Ready, "/readyz"); code != http.StatusServiceUnavailable || report.Checks[0].Error != "not created yet" {
+		t.Errorf("readyz before the first attempt = %d %+v, want 503", code, report)
+	}
+	indexes.ensure(context.Background(), res)
+	if code, report := probe(t, h.Ready, "/readyz"); code != http.StatusServiceUnavailable || report.Checks[0].Error != res.blocked.Error() {
+		t.Errorf("readyz with the index blocked = %d %+v, want 503 naming the conflict", code, report)
+	}
+	res.blocked = nil
+	indexes.ensure(context.Background(), res)
+	if code, report := probe(t, h.Ready, "/readyz"); code != http.StatusOK {
+		t.Errorf("readyz once created = %d %+v, want 200", code, report)
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"kindergarten-core/crud"
+	"kindergarten-core/metrics"
+	"kindergarten-core/recorder"
+	"kindergarten-core/tracing"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverPanics turns a panic in handler into a 500 problem response. The
+// panic and its stack trace go to the log and to APM, and are counted in
+// http_panics_total. http.ErrAbortHandler is passed on: handlers panic with
+// it on purpose to break the connection, as the export does.
+func recoverPanics(handler http.HandlerFunc, route string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := recorder.Wrap(w)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			ctx := r.Context()
+			slog.ErrorContext(ctx, "Panic in handler",
+				"http.route", route,
+				"error.message", v,
+				"error.stack_trace", string(debug.Stack()),
+			)
+			tracing.CapturePanic(ctx, v)
+			metrics.Panic(route)
+			if rec.Written() {
+				// part of the response is out; break the connection so the
+				// client does not take it for a complete one
+				panic(http.ErrAbortHandler)
+			}
+			crud.InternalError(rec, r)
+		}()
+		handler(rec, r)
+	}
+}
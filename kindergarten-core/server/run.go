@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"kindergarten-core/config"
+	"kindergarten-core/database"
+	"kindergarten-core/tracing"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// cleanupTimeout bounds flushing traces and disconnecting MongoDB once the HTTP
+// server has drained.
+const cleanupTimeout = 5 * time.Second
+
+var draining atomic.Bool
+
+// Draining reports whether the service is shutting down; it should then
+// report itself not ready so no new traffic is sent to it.
+func Draining() bool {
+	return draining.Load()
+}
+
+// Run serves http.DefaultServeMux on cfg.Port until ctx is done, typically on
+// SIGTERM, and then shuts down gracefully: it reports not ready for
+// cfg.ShutdownDelay, stops accepting connections, gives in-flight requests
+// until cfg.ShutdownTimeout to finish, flushes the traces and disconnects from
+// MongoDB.
+func Run(ctx context.Context, cfg config.Service) error {
+	listener, err := net.Listen("tcp", ":"+strconv.Itoa(cfg.Port))
+	if err != nil {
+		return err
+	}
+	return serve(ctx, cfg, &http.Server{}, listener)
+}
+
+// serve is Run with the server and the listener it accepts connections on.
+func serve(ctx context.Context, cfg config.Service, srv *http.Server, listener net.Listener) error {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(listener)
+	}()
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	draining.Store(true)
+	slog.Info("Shutting down", "not_ready_for", cfg.ShutdownDelay.String(), "drain_timeout", cfg.ShutdownTimeout.String())
+	time.Sleep(cfg.ShutdownDelay)
+
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(drainCtx); err != nil {
+		slog.Warn("Requests still running, closing their connections", "drain_timeout", cfg.ShutdownTimeout.String(), "error", err)
+		srv.Close()
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := tracing.Shutdown(cleanupCtx); err != nil {
+		slog.Warn("Flushing traces failed", "error", err)
+	}
+	if err := database.Disconnect(cleanupCtx); err != nil {
+		slog.Warn("Disconnecting from MongoDB failed", "error", err)
+	}
+	slog.Info("Shutdown complete")
+	return nil
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"io"
+	"kindergarten-core/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowServer serves /slow, which answers once release is closed, and the
+// readiness probe of a started service.
+type slowServer struct {
+	*httptest.Server
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newSlowServer(t *testing.T) *slowServer {
+	t.Helper()
+	s := &slowServer{entered: make(chan struct{}), release: make(chan struct{})}
+	health := NewHealth("Student Service", time.Second)
+	health.Started()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", health.Ready)
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(s.entered)
+		<-s.release
+		io.WriteString(w, "done")
+	})
+	s.Server = httptest.NewUnstartedServer(mux)
+	t.Cleanup(func() {
+		draining.Store(false)
+		select {
+		case <-s.release:
+		default:
+			close(s.release)
+		}
+	})
+	return s
+}
+
+// start serves s with serve until ctx is done and returns what serve returned.
+func (s *slowServer) start(ctx context.Context, cfg config.Service) <-chan error {
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, cfg, s.Config, s.Listener)
+	}()
+	return served
+}
+
+// slowRequest calls /slow and waits until the handler is running.
+func (s *slowServer) slowRequest(t *testing.T) <-chan error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + s.Listener.Addr().String() + "/slow")
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "done" {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		done <- err
+	}()
+	select {
+	case <-s.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("request never reached the handler")
+	}
+	return done
+}
+
+func TestRunDrainsRequests(t *testing.T) {
+	s := newSlowServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := s.start(ctx, config.Service{ShutdownDelay: 200 * time.Millisecond, ShutdownTimeout: 5 * time.Second})
+	inFlight := s.slowRequest(t)
+
+	cancel()
+	for deadline := time.Now().Add(time.Second); !Draining(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("not draining after the context was cancelled")
+		}
+	}
+
+	// load balancers still reach the service during the delay and learn it is going
+	resp, err := http.Get("http://" + s.Listener.Addr().String() + "/readyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(string(body), `"status":"draining"`) {
+		t.Errorf("readyz = %d %s, want 503 draining", resp.StatusCode, body)
+	}
+
+	select {
+	case err := <-served:
+		t.Fatalf("Run returned %v with a request in flight", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	close(s.release)
+	if err := <-inFlight; err != nil {
+		t.Errorf("in-flight request failed: %v", err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Run = %v, want a clean shutdown", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return once the request finished")
+	}
+
+	if _, err := http.Get("http://" + s.Listener.Addr().String() + "/readyz"); err == nil {
+		t.Error("server still accepting connections after shutdown")
+	}
+}
+
+func TestRunShutdownTimeout(t *testing.T) {
+	s := newSlowServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg := config.Service{ShutdownTimeout: 100 * time.Millisecond}
+	served := s.start(ctx, cfg)
+	inFlight := s.slowRequest(t)
+
+	start := time.Now()
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Run = %v, want nil after closing the stuck connection", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run waited past the shutdown timeout")
+	}
+	if elapsed := time.Since(start); elapsed < cfg.ShutdownTimeout {
+		t.Errorf("Run returned after %v, want it to wait %v for the request", elapsed, cfg.ShutdownTimeout)
+	}
+	if err := <-inFlight; err == nil {
+		t.Error("stuck request succeeded, want its connection closed")
+	}
+}
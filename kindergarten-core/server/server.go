@@ -0,0 +1,73 @@
+// Package server holds the HTTP plumbing the kindergarten services share:
+// CORS, request logging, tracing and metrics, panic recovery, authentication,
+// route registration, probes and graceful shutdown.
+package server
+
+import (
+	"kindergarten-core/crud"
+	"kindergarten-core/database"
+	"kindergarten-core/logging"
+	"kindergarten-core/metrics"
+	"kindergarten-core/tracing"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Options control how Register exposes routes.
+type Options struct {
+	// Prefix goes in front of every path, e.g. "/std" behind the k8s ingress.
+	Prefix string
+}
+
+func EnableCors(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, X-Request-ID, traceparent, tracestate, elastic-apm-traceparent")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Total-Count, X-Next-Cursor, Link, Content-Disposition, X-Request-ID")
+}
+
+// Register adds routes to http.DefaultServeMux. Every path answers CORS
+// preflight requests and rejects methods it has no handler for. While
+// authentication is on, the routes require a bearer token.
+func Register(routes []crud.Route, opts Options) {
+	byPath := map[string]map[string]http.HandlerFunc{}
+	var paths []string
+	for _, route := range routes {
+		if byPath[route.Path] == nil {
+			byPath[route.Path] = map[string]http.HandlerFunc{}
+			paths = append(paths, route.Path)
+		}
+		handler := database.Middleware(route.Handler)
+		handler = authenticate(handler)
+		handler = recoverPanics(handler, route.Path)
+		handler = logging.Middleware(handler, route.Path)
+		handler = tracing.Middleware(handler, route.Path)
+		handler = metrics.Middleware(handler, route.Path)
+		byPath[route.Path][route.Method] = handler
+	}
+
+	for _, path := range paths {
+		methods := byPath[path]
+		allowed := make([]string, 0, len(methods))
+		for method := range methods {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+		allow := strings.Join(append(allowed, http.MethodOptions), ", ")
+
+		http.HandleFunc(opts.Prefix+path, func(w http.ResponseWriter, r *http.Request) {
+			EnableCors(w)
+			if r.Method == http.MethodOptions {
+				return
+			}
+			handler, ok := methods[r.Method]
+			if !ok {
+				w.Header().Set("Allow", allow)
+				crud.MethodNotAllowed(w, r)
+				return
+			}
+			handler(w, r)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"go.elastic.co/apm/v2"
+)
+
+// Custom metrics sent to Elastic APM next to the agent's own, labelled with
+// the entity and tenant of the request that counted them. Each report carries
+// the count since the one before, so summing them over a time range in Kibana
+// gives the count in that range.
+const (
+	// DuplicateRejections counts records refused because their key was taken.
+	DuplicateRejections = "kindergarten.duplicate_rejections"
+	// ValidationFailures counts requests refused for invalid fields.
+	ValidationFailures = "kindergarten.validation_failures"
+	// VersionConflicts counts writes refused because If-Match named an old version.
+	VersionConflicts = "kindergarten.version_conflicts"
+)
+
+type counterKey struct {
+	name, entity, tenant string
+}
+
+// counters maps a counterKey to the *atomic.Int64 counting it.
+var counters sync.Map
+
+// Count adds n to the custom metric name for the entity and tenant labels of
+// the transaction of ctx. It is a no-op unless spans go to Elastic APM.
+func Count(ctx context.Context, name string, n int) {
+	if apmTracer == nil {
+		return
+	}
+	key := counterKey{name: name}
+	key.entity, _ = Label(ctx, "entity").(string)
+	key.tenant, _ = Label(ctx, "tenant").(string)
+	counter, _ := counters.LoadOrStore(key, new(atomic.Int64))
+	counter.(*atomic.Int64).Add(int64(n))
+}
+
+// gatherCounters reports the counts since the last report.
+func gatherCounters(_ context.Context, m *apm.Metrics) error {
+	counters.Range(func(k, counter any) bool {
+		key := k.(counterKey)
+		var labels []apm.MetricLabel
+		if key.entity != "" {
+			labels = append(labels, apm.MetricLabel{Name: "entity", Value: key.entity})
+		}
+		if key.tenant != "" {
+			labels = append(labels, apm.MetricLabel{Name: "tenant", Value: key.tenant})
+		}
+		m.Add(key.name, labels, float64(counter.(*atomic.Int64).Swap(0)))
+		return true
+	})
+	return nil
+}
@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"context"
+	"kindergarten-core/recorder"
+	"net/http"
+	"strconv"
+
+	"go.elastic.co/apm/v2"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ElasticTraceparentHeader is the traceparent of older Elastic agents, the
+// RUM agent among them, which send it next to or instead of the W3C one.
+const ElasticTraceparentHeader = "Elastic-Apm-Traceparent"
+
+// Middleware records each request handled by handler as a transaction named
+// after its method and route, the path pattern it matched. A request carrying
+// a W3C traceparent header, or an Elastic one, continues that trace, so a
+// click in the frontend and the backend request it caused form a single
+// trace. The transaction records the request, its route and the status code
+// of the response, and is labelled with the route and the tenant; handlers
+// add labels of their own with SetLabel.
+func Middleware(handler http.HandlerFunc, route string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if apmTracer == nil && otelTracer == nil {
+			handler(w, r)
+			return
+		}
+
+		name := r.Method + " " + route
+		ctx := propagator.Extract(r.Context(), traceHeaders(r.Header))
+		s := &Span{}
+		req := &request{labels: map[string]any{}}
+		ctx = context.WithValue(ctx, requestKey{}, req)
+		var ids *apm.TraceContext
+		if apmTracer != nil {
+			var opts apm.TransactionOptions
+			if remote := trace.SpanContextFromContext(ctx); remote.IsValid() {
+				opts.TraceContext = apm.TraceContext{
+					Trace:   apm.TraceID(remote.TraceID()),
+					Span:    apm.SpanID(remote.SpanID()),
+					Options: apm.TraceOptions(0).WithRecorded(remote.IsSampled()),
+					State:   traceState(remote.TraceState()),
+				}
+			}
+			s.tx = apmTracer.StartTransactionOptions(name, "request", opts)
+			s.tx.Context.SetHTTPRequest(r)
+			req.tx = s.tx
+			ctx = apm.ContextWithTransaction(ctx, s.tx)
+			tc := s.tx.TraceContext()
+			ids = &tc
+		}
+		if otelTracer != nil {
+			s.otel, ctx = startOTel(ctx, ids, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(r.URL.Path),
+				semconv.URLQuery(r.URL.RawQuery),
+				semconv.URLScheme(scheme(r)),
+				semconv.ServerAddress(r.Host),
+				semconv.ClientAddress(r.RemoteAddr),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			))
+		}
+		req.otel = s.otel
+		defer s.End()
+
+		SetLabel(ctx, "route", route)
+		if tenant != "" {
+			SetLabel(ctx, "tenant", tenant)
+		}
+
+		rec := recorder.Wrap(w)
+		handler(rec, r.WithContext(ctx))
+
+		status := rec.Status()
+		if s.tx != nil {
+			s.tx.Context.SetHTTPStatusCode(status)
+			s.tx.Result = "HTTP " + strconv.Itoa(status/100) + "xx"
+			if status >= http.StatusInternalServerError {
+				s.tx.Outcome = "failure"
+			} else {
+				s.tx.Outcome = "success"
+			}
+		}
+		if s.otel != nil {
+			s.otel.SetAttributes(semconv.HTTPResponseStatusCode(status))
+			if status >= http.StatusInternalServerError {
+				s.otel.SetStatus(codes.Error, http.StatusText(status))
+			}
+		}
+	}
+}
+
+// traceHeaders returns the trace headers of a request for the propagator,
+// taking the traceparent from the Elastic header when the W3C one is missing.
+func traceHeaders(h http.Header) propagation.HeaderCarrier {
+	if h.Get("traceparent") != "" || h.Get(ElasticTraceparentHeader) == "" {
+		return propagation.HeaderCarrier(h)
+	}
+	h = h.Clone()
+	h.Set("traceparent", h.Get(ElasticTraceparentHeader))
+	return propagation.HeaderCarrier(h)
+}
+
+// traceState carries the tracestate of the caller over to the transaction, so
+// the vendor entries in it, such as the sample rate of the RUM agent, travel
+// on with the trace.
+func traceState(ts trace.TraceState) apm.TraceState {
+	var entries []apm.TraceStateEntry
+	ts.Walk(func(key, value string) bool {
+		entries = append(entries, apm.TraceStateEntry{Key: key, Value: value})
+		return true
+	})
+	return apm.NewTraceState(entries...)
+}
+
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,91 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.elastic.co/apm/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tenant is the branch requests are labelled with unless their bearer token
+// names another. It is never taken from a request header: clients could make
+// up tenants, and every tenant adds a series to the custom metrics.
+var tenant string
+
+// request holds what Middleware started for a request, so labels set deep in
+// a handler land on the transaction rather than on the innermost span.
+type request struct {
+	mu     sync.Mutex
+	tx     *apm.Transaction
+	otel   trace.Span
+	labels map[string]any
+}
+
+type requestKey struct{}
+
+func requestOf(ctx context.Context) *request {
+	req, _ := ctx.Value(requestKey{}).(*request)
+	return req
+}
+
+// SetLabel labels the transaction of ctx, and the errors captured in it, with
+// key and value. Values are strings, numbers or booleans; Kibana can filter
+// and group transactions by them. Setting a key again replaces its value.
+func SetLabel(ctx context.Context, key string, value any) {
+	req := requestOf(ctx)
+	if req == nil {
+		return
+	}
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	req.labels[key] = value
+	if req.tx != nil {
+		req.tx.Context.SetLabel(key, value)
+	}
+	if req.otel != nil {
+		req.otel.SetAttributes(attributeOf(key, value))
+	}
+}
+
+// Label returns the value of the label key of the transaction of ctx, or nil.
+func Label(ctx context.Context, key string) any {
+	req := requestOf(ctx)
+	if req == nil {
+		return nil
+	}
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	return req.labels[key]
+}
+
+// labelError copies the labels of the transaction of ctx to e.
+func labelError(ctx context.Context, e *apm.Error) {
+	req := requestOf(ctx)
+	if req == nil {
+		return
+	}
+	req.mu.Lock()
+	defer req.mu.Unlock()
+	for key, value := range req.labels {
+		e.Context.SetLabel(key, value)
+	}
+}
+
+func attributeOf(key string, value any) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	case bool:
+		return attribute.Bool(key, v)
+	}
+	return attribute.String(key, fmt.Sprint(value))
+}
@@ -0,0 +1,155 @@
+package tracing
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.elastic.co/apm/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxStatementLength bounds the statement recorded on a span; an import
+// inserts hundreds of documents in one command.
+const maxStatementLength = 2000
+
+// commandFields are sent with every command by the driver and say nothing
+// about the query, so they are left out of the statement.
+var commandFields = map[string]bool{
+	"$db": true, "lsid": true, "$clusterTime": true, "$readPreference": true, "txnNumber": true,
+	"autocommit": true, "startTransaction": true, "signature": true, "apiVersion": true,
+}
+
+// CommandMonitor records a span, such as "students.find", for every MongoDB
+// command run within a transaction, as a child of the span in the command's
+// context. The statement on the span is the command with every value
+// replaced by ?, so no personal data leaves the service.
+func CommandMonitor() *event.CommandMonitor {
+	var spans sync.Map // request ID -> *Span
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			if s := startCommandSpan(ctx, evt); s != nil {
+				spans.Store(evt.RequestID, s)
+			}
+		},
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			if s, ok := spans.LoadAndDelete(evt.RequestID); ok {
+				s.(*Span).End()
+			}
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			if s, ok := spans.LoadAndDelete(evt.RequestID); ok {
+				s := s.(*Span)
+				if s.span != nil {
+					s.span.Outcome = "failure"
+				}
+				if s.otel != nil {
+					s.otel.SetStatus(codes.Error, evt.Failure)
+				}
+				s.End()
+			}
+		},
+	}
+}
+
+// startCommandSpan returns nil outside of a transaction.
+func startCommandSpan(ctx context.Context, evt *event.CommandStartedEvent) *Span {
+	if apmTracer == nil && otelTracer == nil {
+		return nil
+	}
+	collection, ok := evt.Command.Lookup(evt.CommandName).StringValueOK()
+	if !ok {
+		// getMore names the collection in a field of its own
+		collection, _ = evt.Command.Lookup("collection").StringValueOK()
+	}
+	name := evt.CommandName
+	if collection != "" {
+		name = collection + "." + evt.CommandName
+	}
+	stmt := statement(evt.Command)
+
+	s := &Span{}
+	var ids *apm.TraceContext
+	if apmTracer != nil && apm.TransactionFromContext(ctx) != nil {
+		s.span, _ = apm.StartSpanOptions(ctx, name, "db.mongodb.query", apm.SpanOptions{ExitSpan: true})
+		s.span.Context.SetDatabase(apm.DatabaseSpanContext{Type: "mongodb", Instance: evt.DatabaseName, Statement: stmt})
+		tc := s.span.TraceContext()
+		ids = &tc
+	}
+	if otelTracer != nil && trace.SpanContextFromContext(ctx).IsValid() {
+		s.otel, _ = startOTel(ctx, ids, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+			semconv.DBSystemMongoDB,
+			semconv.DBNamespace(evt.DatabaseName),
+			semconv.DBCollectionName(collection),
+			semconv.DBOperationName(evt.CommandName),
+			semconv.DBQueryText(stmt),
+		))
+	}
+	if s.span == nil && s.otel == nil {
+		return nil
+	}
+	return s
+}
+
+// statement renders cmd like {"find": "students", "filter": {"roll": ?}}:
+// the command and its collection as they are, field names and operators
+// kept, values replaced by ? and arrays cut to their first element.
+func statement(cmd bson.Raw) string {
+	var b strings.Builder
+	writeDocument(&b, cmd, true)
+	if b.Len() > maxStatementLength {
+		return b.String()[:maxStatementLength] + "..."
+	}
+	return b.String()
+}
+
+func writeDocument(b *strings.Builder, doc bson.Raw, command bool) {
+	elems, err := doc.Elements()
+	if err != nil {
+		b.WriteString("?")
+		return
+	}
+	b.WriteString("{")
+	written := 0
+	for i, elem := range elems {
+		key := elem.Key()
+		if command && commandFields[key] {
+			continue
+		}
+		if written > 0 {
+			b.WriteString(", ")
+		}
+		written++
+		b.WriteString(strconv.Quote(key) + ": ")
+		if s, ok := elem.Value().StringValueOK(); ok && command && i == 0 {
+			b.WriteString(strconv.Quote(s))
+			continue
+		}
+		writeValue(b, elem.Value())
+	}
+	b.WriteString("}")
+}
+
+func writeValue(b *strings.Builder, v bson.RawValue) {
+	switch v.Type {
+	case bson.TypeEmbeddedDocument:
+		writeDocument(b, v.Document(), false)
+	case bson.TypeArray:
+		values, _ := v.Array().Values()
+		b.WriteString("[")
+		if len(values) > 0 {
+			writeValue(b, values[0])
+		}
+		if len(values) > 1 {
+			b.WriteString(", ...")
+		}
+		b.WriteString("]")
+	default:
+		b.WriteString("?")
+	}
+}
@@ -0,0 +1,104 @@
+package tracing
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestStatement(t *testing.T) {
+	session := bson.D{{Key: "id", Value: primitive.Binary{Subtype: 4, Data: make([]byte, 16)}}}
+	tests := []struct {
+		name string
+		cmd  bson.D
+		want string
+	}{
+		{
+			name: "find",
+			cmd: bson.D{
+				{Key: "find", Value: "students"},
+				{Key: "filter", Value: bson.D{{Key: "name", Value: "Ann Rahman"}, {Key: "roll", Value: bson.D{{Key: "$in", Value: bson.A{"7", "8"}}}}}},
+				{Key: "limit", Value: int64(50)},
+				{Key: "$db", Value: "kindergarten"},
+				{Key: "lsid", Value: session},
+			},
+			want: `{"find": "students", "filter": {"name": ?, "roll": {"$in": [?, ...]}}, "limit": ?}`,
+		},
+		{
+			name: "insert",
+			cmd: bson.D{
+				{Key: "insert", Value: "students"},
+				{Key: "ordered", Value: false},
+				{Key: "documents", Value: bson.A{
+					bson.D{{Key: "roll", Value: "7"}, {Key: "address", Value: "12 Lake Road"}},
+					bson.D{{Key: "roll", Value: "8"}, {Key: "address", Value: "3 Hill Street"}},
+				}},
+			},
+			want: `{"insert": "students", "ordered": ?, "documents": [{"roll": ?, "address": ?}, ...]}`,
+		},
+		{
+			name: "aggregate",
+			cmd: bson.D{
+				{Key: "aggregate", Value: "students"},
+				{Key: "pipeline", Value: bson.A{
+					bson.D{{Key: "$match", Value: bson.D{{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}}}}},
+				}},
+				{Key: "cursor", Value: bson.D{}},
+			},
+			want: `{"aggregate": "students", "pipeline": [{"$match": {"deleted_at": {"$exists": ?}}}], "cursor": {}}`,
+		},
+		{
+			name: "only the command's own string is kept",
+			cmd: bson.D{
+				{Key: "getMore", Value: int64(81723)},
+				{Key: "collection", Value: "students"},
+			},
+			want: `{"getMore": ?, "collection": ?}`,
+		},
+		{
+			name: "empty array",
+			cmd: bson.D{
+				{Key: "update", Value: "students"},
+				{Key: "updates", Value: bson.A{}},
+			},
+			want: `{"update": "students", "updates": []}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := bson.Marshal(tt.cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := statement(cmd); got != tt.want {
+				t.Errorf("statement =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatementLength(t *testing.T) {
+	filter := bson.D{}
+	for i := 0; i < 500; i++ {
+		filter = append(filter, bson.E{Key: "field" + strings.Repeat("x", i%7), Value: "secret"})
+	}
+	cmd, err := bson.Marshal(bson.D{{Key: "find", Value: "students"}, {Key: "filter", Value: filter}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := statement(cmd)
+	if len(got) != maxStatementLength+len("...") || !strings.HasSuffix(got, "...") {
+		t.Errorf("statement of %d bytes, want it cut to %d", len(got), maxStatementLength)
+	}
+	if strings.Contains(got, "secret") {
+		t.Error("statement contains a value")
+	}
+}
+
+func TestStatementMalformed(t *testing.T) {
+	if got := statement(bson.Raw{0x05, 0x00}); got != "?" {
+		t.Errorf("statement = %q, want ?", got)
+	}
+}
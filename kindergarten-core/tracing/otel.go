@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"kindergarten-core/config"
+	"math/rand/v2"
+
+	"go.elastic.co/apm/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// propagator reads and writes W3C traceparent and tracestate headers.
+var propagator = propagation.TraceContext{}
+
+func initOTel(ctx context.Context, service string, cfg config.Tracing) error {
+	var exporter *otlptrace.Exporter
+	var err error
+	if cfg.OTLPProtocol == "http/protobuf" {
+		var opts []otlptracehttp.Option
+		if cfg.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	} else {
+		var opts []otlptracegrpc.Option
+		if cfg.OTLPEndpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.OTLPEndpoint))
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	}
+	if err != nil {
+		return err
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service)))
+	if err != nil {
+		return err
+	}
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithIDGenerator(idGenerator{}),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+	otelTracer = provider.Tracer("kindergarten-core/tracing")
+	return nil
+}
+
+// otlpTarget names where OTLP exports go, for the startup log.
+func otlpTarget(cfg config.Tracing) string {
+	if cfg.OTLPEndpoint != "" {
+		return cfg.OTLPEndpoint
+	}
+	return "the default endpoint"
+}
+
+// idsKey carries the IDs of the Elastic span an OpenTelemetry span mirrors
+// into idGenerator.
+type idsKey struct{}
+
+// startOTel starts an OpenTelemetry span, with the trace and span ID of ids
+// when it is not nil. The IDs are only in the context Start sees, so spans
+// started later from the returned context get IDs of their own.
+func startOTel(ctx context.Context, ids *apm.TraceContext, name string, opts ...trace.SpanStartOption) (trace.Span, context.Context) {
+	parent := ctx
+	if ids != nil {
+		ctx = context.WithValue(ctx, idsKey{}, ids)
+	}
+	_, span := otelTracer.Start(ctx, name, opts...)
+	return span, trace.ContextWithSpan(parent, span)
+}
+
+// idGenerator hands out the IDs of the mirrored Elastic span, if any, and
+// random ones otherwise.
+type idGenerator struct{}
+
+func (idGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	if ids, ok := ctx.Value(idsKey{}).(*apm.TraceContext); ok {
+		return trace.TraceID(ids.Trace), trace.SpanID(ids.Span)
+	}
+	return randomTraceID(), randomSpanID()
+}
+
+func (idGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	if ids, ok := ctx.Value(idsKey{}).(*apm.TraceContext); ok && trace.TraceID(ids.Trace) == traceID {
+		return trace.SpanID(ids.Span)
+	}
+	return randomSpanID()
+}
+
+func randomTraceID() trace.TraceID {
+	var id trace.TraceID
+	for id == (trace.TraceID{}) {
+		for i := range id {
+			id[i] = byte(rand.Uint32())
+		}
+	}
+	return id
+}
+
+func randomSpanID() trace.SpanID {
+	var id trace.SpanID
+	for id == (trace.SpanID{}) {
+		for i := range id {
+			id[i] = byte(rand.Uint32())
+		}
+	}
+	return id
+}
@@ -0,0 +1,190 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"kindergarten-core/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	coltrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// receiver collects the spans exported to it over OTLP.
+type receiver struct {
+	coltrace.UnimplementedTraceServiceServer
+	mu      sync.Mutex
+	service string
+	spans   []*tracepb.Span
+}
+
+func (rc *receiver) Export(_ context.Context, req *coltrace.ExportTraceServiceRequest) (*coltrace.ExportTraceServiceResponse, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		if name := attributeValue(rs.Resource.GetAttributes(), "service.name"); name != nil {
+			rc.service = name.GetStringValue()
+		}
+		for _, ss := range rs.ScopeSpans {
+			rc.spans = append(rc.spans, ss.Spans...)
+		}
+	}
+	return &coltrace.ExportTraceServiceResponse{}, nil
+}
+
+// serveHTTP receives OTLP/HTTP with protobuf bodies.
+func (rc *receiver) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req coltrace.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp, _ := rc.Export(r.Context(), &req)
+	out, _ := proto.Marshal(resp)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(out)
+}
+
+// startReceiver starts a receiver for protocol and returns its endpoint URL.
+func startReceiver(t *testing.T, protocol string) (*receiver, string) {
+	t.Helper()
+	rc := &receiver{}
+	if protocol == "http/protobuf" {
+		srv := httptest.NewServer(http.HandlerFunc(rc.serveHTTP))
+		t.Cleanup(srv.Close)
+		return rc, srv.URL + "/v1/traces"
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	coltrace.RegisterTraceServiceServer(srv, rc)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return rc, "http://" + lis.Addr().String()
+}
+
+func (rc *receiver) span(name string) *tracepb.Span {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, s := range rc.spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func attributeValue(attrs []*commonpb.KeyValue, key string) *commonpb.AnyValue {
+	for _, kv := range attrs {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return nil
+}
+
+// initOTLP exports to endpoint over protocol until the test ends.
+func initOTLP(t *testing.T, protocol, endpoint string) {
+	t.Helper()
+	cfg := config.Tracing{Exporter: "otlp", OTLPProtocol: protocol, OTLPEndpoint: endpoint, Tenant: "north"}
+	if err := Init(context.Background(), "Student Service", cfg, config.APM{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { otelTracer, provider, tenant = nil, nil, "" })
+}
+
+func TestOTLPExport(t *testing.T) {
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		callerID = "00f067aa0ba902b7"
+	)
+	for _, protocol := range []string{"grpc", "http/protobuf"} {
+		t.Run(protocol, func(t *testing.T) {
+			rc, endpoint := startReceiver(t, protocol)
+			initOTLP(t, protocol, endpoint)
+
+			handler := Middleware(func(w http.ResponseWriter, r *http.Request) {
+				span, ctx := StartSpan(r.Context(), "students.find", "db.mongodb.query")
+				CaptureError(ctx, errors.New("connection reset"))
+				span.End()
+				SetLabel(r.Context(), "entity", "student")
+				w.WriteHeader(http.StatusInternalServerError)
+			}, "/students")
+			r := httptest.NewRequest(http.MethodGet, "/students?limit=5", nil)
+			r.Header.Set("traceparent", "00-"+traceID+"-"+callerID+"-01")
+			handler(httptest.NewRecorder(), r)
+
+			if err := Shutdown(context.Background()); err != nil {
+				t.Fatalf("shutdown: %v", err)
+			}
+
+			server, db := rc.span("GET /students"), rc.span("students.find")
+			if server == nil || db == nil {
+				t.Fatalf("request span %v, query span %v, want both exported", server, db)
+			}
+			rc.mu.Lock()
+			if rc.service != "Student Service" {
+				t.Errorf("service.name = %q", rc.service)
+			}
+			rc.mu.Unlock()
+
+			if got := hex.EncodeToString(server.TraceId); got != traceID {
+				t.Errorf("trace ID = %s, want the caller's %s", got, traceID)
+			}
+			if got := hex.EncodeToString(server.ParentSpanId); got != callerID {
+				t.Errorf("parent = %s, want the caller's span %s", got, callerID)
+			}
+			if server.Kind != tracepb.Span_SPAN_KIND_SERVER || server.Status.GetCode() != tracepb.Status_STATUS_CODE_ERROR {
+				t.Errorf("request span kind %v status %v, want a failed server span", server.Kind, server.Status)
+			}
+			for key, want := range map[string]string{"http.route": "/students", "entity": "student", "tenant": "north", "url.query": "limit=5"} {
+				if got := attributeValue(server.Attributes, key).GetStringValue(); got != want {
+					t.Errorf("request span %s = %q, want %q", key, got, want)
+				}
+			}
+			if got := attributeValue(server.Attributes, "http.response.status_code").GetIntValue(); got != http.StatusInternalServerError {
+				t.Errorf("status code = %d", got)
+			}
+
+			if string(db.TraceId) != string(server.TraceId) || string(db.ParentSpanId) != string(server.SpanId) {
+				t.Error("query span is not a child of the request span")
+			}
+			if db.Kind != tracepb.Span_SPAN_KIND_CLIENT || attributeValue(db.Attributes, "db.system").GetStringValue() != "mongodb" {
+				t.Errorf("query span kind %v attributes %v, want a mongodb client span", db.Kind, db.Attributes)
+			}
+			if db.Status.GetMessage() != "connection reset" || len(db.Events) != 1 || db.Events[0].Name != "exception" {
+				t.Errorf("query span status %v events %v, want the captured error", db.Status, db.Events)
+			}
+		})
+	}
+}
+
+func TestOTLPExportWithoutTransaction(t *testing.T) {
+	rc, endpoint := startReceiver(t, "http/protobuf")
+	initOTLP(t, "http/protobuf", endpoint)
+
+	span, _ := StartSpan(context.Background(), "students.find", "db.mongodb.query")
+	span.End()
+	if err := Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if rc.span("students.find") != nil {
+		t.Error("span exported outside of a request")
+	}
+}
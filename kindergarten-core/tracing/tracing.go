@@ -0,0 +1,185 @@
+// Package tracing records request transactions and the spans within them,
+// exporting to Elastic APM, to an OpenTelemetry collector over OTLP, or to
+// both. With both, the OpenTelemetry spans reuse the IDs of the Elastic ones,
+// so a trace has the same ID in Kibana and in the OTLP backend.
+//
+// Handlers use StartSpan and CaptureError instead of the Elastic agent
+// directly; they are no-ops when tracing is off.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"kindergarten-core/config"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.elastic.co/apm/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// apmTracer is nil unless spans go to Elastic APM
+	apmTracer *apm.Tracer
+	// otelTracer is nil unless spans go to an OTLP collector
+	otelTracer trace.Tracer
+	provider   *sdktrace.TracerProvider
+)
+
+// Init sets up the exporters cfg.Exporter names. The Elastic agent
+// configures itself from ELASTIC_APM_* variables, so settings that may have
+// come from Vault are exported before it starts.
+func Init(ctx context.Context, service string, cfg config.Tracing, apmCfg config.APM) error {
+	tenant = cfg.Tenant
+	if cfg.Exporter == "elastic" || cfg.Exporter == "both" {
+		if apmCfg.ServerURL != "" {
+			os.Setenv("ELASTIC_APM_SERVER_URL", apmCfg.ServerURL)
+		}
+		if apmCfg.SecretToken != "" {
+			os.Setenv("ELASTIC_APM_SECRET_TOKEN", apmCfg.SecretToken)
+		}
+		if tracer := apm.DefaultTracer(); tracer.Active() {
+			apmTracer = tracer
+			apmTracer.RegisterMetricsGatherer(apm.GatherMetricsFunc(gatherCounters))
+			slog.Info("APM initialized")
+		} else {
+			slog.Warn("APM not active - using environment variables")
+		}
+	}
+	if cfg.Exporter == "otlp" || cfg.Exporter == "both" {
+		if err := initOTel(ctx, service, cfg); err != nil {
+			return err
+		}
+		slog.Info("Exporting traces over OTLP", "protocol", cfg.OTLPProtocol, "endpoint", otlpTarget(cfg))
+	}
+	return nil
+}
+
+// Shutdown sends the spans still buffered and stops the exporters.
+func Shutdown(ctx context.Context) error {
+	if apmTracer != nil {
+		apmTracer.Flush(ctx.Done())
+		apmTracer.Close()
+	}
+	if provider != nil {
+		return provider.Shutdown(ctx)
+	}
+	return nil
+}
+
+// Span is a span in each of the enabled exporters.
+type Span struct {
+	tx   *apm.Transaction
+	span *apm.Span
+	otel trace.Span
+}
+
+// End ends the span.
+func (s *Span) End() {
+	if s.tx != nil {
+		s.tx.End()
+	}
+	if s.span != nil {
+		s.span.End()
+	}
+	if s.otel != nil {
+		s.otel.End()
+	}
+}
+
+// StartSpan starts a span of spanType, such as "db.mongodb.query", within the
+// transaction of ctx. Without a transaction the span is not recorded. The
+// handlers wrap each operation in an "app" span; the MongoDB commands it runs
+// get spans of their own from CommandMonitor, nested inside it.
+func StartSpan(ctx context.Context, name, spanType string) (*Span, context.Context) {
+	s := &Span{}
+	var ids *apm.TraceContext
+	if apmTracer != nil && apm.TransactionFromContext(ctx) != nil {
+		s.span, ctx = apm.StartSpan(ctx, name, spanType)
+		tc := s.span.TraceContext()
+		ids = &tc
+	}
+	if otelTracer != nil && trace.SpanContextFromContext(ctx).IsValid() {
+		kind, attrs := spanKind(spanType)
+		s.otel, ctx = startOTel(ctx, ids, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+	}
+	return s, ctx
+}
+
+// spanKind maps an Elastic span type to an OpenTelemetry kind and attributes:
+// "db.mongodb.query" is a client span with db.system mongodb.
+func spanKind(spanType string) (trace.SpanKind, []attribute.KeyValue) {
+	parts := strings.Split(spanType, ".")
+	if parts[0] == "db" && len(parts) > 1 {
+		return trace.SpanKindClient, []attribute.KeyValue{attribute.String("db.system", parts[1])}
+	}
+	return trace.SpanKindInternal, nil
+}
+
+// CaptureError reports err on the transaction or span of ctx.
+func CaptureError(ctx context.Context, err error) {
+	if apmTracer != nil {
+		e := apm.CaptureError(ctx, err)
+		labelError(ctx, e)
+		e.Send()
+	}
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// CapturePanic reports v, recovered from a panic in a handler, with the stack
+// trace of the panic on the transaction of ctx. It must be called straight
+// from the deferred function that recovered v.
+func CapturePanic(ctx context.Context, v any) {
+	if apmTracer != nil {
+		if tx := apm.TransactionFromContext(ctx); tx != nil {
+			e := apmTracer.Recovered(v)
+			// start the stack trace at the panic, not here
+			e.SetStacktrace(3)
+			e.SetTransaction(tx)
+			e.Handled = true
+			labelError(ctx, e)
+			e.Send()
+		}
+	}
+	if span := trace.SpanFromContext(ctx); span.IsRecording() {
+		span.RecordError(fmt.Errorf("panic: %v", v), trace.WithStackTrace(true))
+		span.SetStatus(codes.Error, fmt.Sprint(v))
+	}
+}
+
+// IDs identify where in a trace a log line was written.
+type IDs struct {
+	Trace       string
+	Transaction string
+	Span        string
+}
+
+// IDsOf returns the IDs of the trace, transaction and span of ctx, any of
+// which is empty when ctx is not in one. Only Elastic has transactions.
+func IDsOf(ctx context.Context) IDs {
+	var ids IDs
+	if tx := apm.TransactionFromContext(ctx); tx != nil {
+		tc := tx.TraceContext()
+		ids.Trace, ids.Transaction = tc.Trace.String(), tc.Span.String()
+		if span := apm.SpanFromContext(ctx); span != nil {
+			ids.Span = span.TraceContext().Span.String()
+		}
+		return ids
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		ids.Trace, ids.Span = sc.TraceID().String(), sc.SpanID().String()
+	}
+	return ids
+}
+
+// TraceID returns the ID of the trace ctx belongs to, or "" outside of one.
+func TraceID(ctx context.Context) string {
+	return IDsOf(ctx).Trace
+}
@@ -1,67 +1,20 @@
 package main
 
 import (
-	"log"
-	"net/http"
-	"studentservice/database"
-	"studentservice/handlers"
+	"kindergarten-core/config"
+	"kindergarten-core/crud"
+	"kindergarten-core/server"
+	"studentservice/models"
 )
 
-func enableCors(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, traceparent, tracestate")
-}
-
 func main() {
-
-
-	// Database connection
-	if err := database.Connect(); err != nil {
-		log.Fatal("Database connection failed:", err)
-	}
-
-	// Student Routes with APM middleware
-	http.HandleFunc("/add-student", func(w http.ResponseWriter, r *http.Request) {
-		enableCors(w)
-		if r.Method == http.MethodOptions {
-			return
-		}
-		handlers.AddStudent(w, r)
-	})
-
-	http.HandleFunc("/students", func(w http.ResponseWriter, r *http.Request) {
-		enableCors(w)
-		if r.Method == http.MethodOptions {
-			return
-		}
-		handlers.GetStudents(w, r)
-	})
-
-	http.HandleFunc("/delete-student", func(w http.ResponseWriter, r *http.Request) {
-		enableCors(w)
-		if r.Method == http.MethodOptions {
-			return
-		}
-		if r.Method != http.MethodDelete {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		handlers.DeleteStudent(w, r)
-	})
-
-	http.HandleFunc("/update-student", func(w http.ResponseWriter, r *http.Request) {
-		enableCors(w)
-		if r.Method == http.MethodOptions {
-			return
-		}
-		if r.Method != http.MethodPut {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		handlers.UpdateStudent(w, r)
-	})
-
-	log.Println("Student Service running on port 5001")
-	log.Fatal(http.ListenAndServe(":5001", nil))
-}
\ No newline at end of file
+	defaults := config.Defaults("Student Service", 5001)
+	// Tracing stays off unless TRACING_EXPORTER turns it on
+	defaults.Tracing.Exporter = "none"
+	server.Main(defaults, crud.NewResource[models.Student](crud.Config{
+		Name:       "Student",
+		Collection: "students",
+		Key:        "roll",
+		KeyLabel:   "roll number",
+	}))
+}
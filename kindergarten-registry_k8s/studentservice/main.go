@@ -1,113 +1,21 @@
 package main
 
 import (
-    "log"
-    "net/http"
-    "os"
-    "strconv"
-    "studentservice/database"
-    "studentservice/handlers"
+    "kindergarten-core/config"
+    "kindergarten-core/crud"
+    "kindergarten-core/server"
+    "studentservice/models"
 )
 
-func enableCors(w http.ResponseWriter) {
-    w.Header().Set("Access-Control-Allow-Origin", "*")
-    w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-    w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-}
-
 func main() {
-    log.Println("Initializing Student Service...")
-    
-    // Step 1: Environment variables থেকে
-    mongoURI := os.Getenv("MONGODB_URI")
-    if mongoURI == "" {
-        log.Println("MONGODB_URI not set in environment")
-    }
-    
-    portStr := os.Getenv("SERVICE_PORT")
-    port := 5001
-    if portStr != "" {
-        if p, err := strconv.Atoi(portStr); err == nil {
-            port = p
-        } else {
-            log.Println("Invalid SERVICE_PORT value, using default port 5001")
-        }
-    } else {
-        log.Println("SERVICE_PORT not set in environment, using default port 5001")
-    }
-    
-    serviceName := os.Getenv("SERVICE_NAME")
-    if serviceName == "" {
-        log.Println("SERVICE_NAME not set in environment")
-    }
-    
-    // Log the configuration details
-    log.Printf("Service: %s", serviceName)
-    log.Printf("Port: %d", port)
-
-    // Step 2: Database connection
-    if mongoURI != "" {
-        os.Setenv("MONGODB_URI", mongoURI)
-        if err := database.Connect(); err != nil {
-            log.Fatal("Database connection failed:", err)
-        }
-    } else {
-        log.Fatal("Cannot proceed without MongoDB URI")
-    }
-
-    // Step 3: HTTP routes setup
-    setupRoutes()
-    
-    log.Printf("Student Service running on port %d", port)
-    log.Fatal(http.ListenAndServe(":"+strconv.Itoa(port), nil))
-}
-
-func setupRoutes() {
-    http.HandleFunc("/std/add-student", func(w http.ResponseWriter, r *http.Request) {
-        enableCors(w)
-        if r.Method == http.MethodOptions {
-            return
-        }
-        handlers.AddStudent(w, r)
-    })
-
-    http.HandleFunc("/std/students", func(w http.ResponseWriter, r *http.Request) {
-        enableCors(w)
-        if r.Method == http.MethodOptions {
-            return
-        }
-        handlers.GetStudents(w, r)
-    })
-
-    http.HandleFunc("/std/delete-student", func(w http.ResponseWriter, r *http.Request) {
-        enableCors(w)
-        if r.Method == http.MethodOptions {
-            return
-        }
-        if r.Method != http.MethodDelete {
-            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-            return
-        }
-        handlers.DeleteStudent(w, r)
-    })
-
-    http.HandleFunc("/std/update-student", func(w http.ResponseWriter, r *http.Request) {
-        enableCors(w)
-        if r.Method == http.MethodOptions {
-            return
-        }
-        if r.Method != http.MethodPut {
-            http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-            return
-        }
-        handlers.UpdateStudent(w, r)
-    })
-
-    // Health check endpoint
-    http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-        enableCors(w)
-        w.Header().Set("Content-Type", "application/json")
-        w.WriteHeader(http.StatusOK)
-        w.Write([]byte(`{"status": "healthy", "service": "student"}`))
-    })
+    defaults := config.Defaults("Student Service", 5001)
+    defaults.Service.Prefix = "/std"
+    // Tracing stays off unless TRACING_EXPORTER turns it on
+    defaults.Tracing.Exporter = "none"
+    server.Main(defaults, crud.NewResource[models.Student](crud.Config{
+        Name:       "Student",
+        Collection: "students",
+        Key:        "roll",
+        KeyLabel:   "roll number",
+    }))
 }
@@ -1,7 +1,10 @@
 package models
 
+import "kindergarten-core/crud"
+
 type Teacher struct {
-    Name    string `json:"name" bson:"name"`
-    ID      string `json:"id" bson:"id"`
-    Subject string `json:"subject" bson:"subject"`
+    Name    string `json:"name" bson:"name" validate:"required,max=100,chars=name"`
+    ID      string `json:"id" bson:"id" validate:"required,max=20,chars=key"`
+    Subject string `json:"subject" bson:"subject" validate:"required,max=100,chars=text"`
+    crud.Meta `bson:",inline"`
 }
\ No newline at end of file
@@ -1,7 +1,10 @@
 package models
 
+import "kindergarten-core/crud"
+
 type Employee struct {
-    Name     string `json:"name" bson:"name"`
-    ID       string `json:"id" bson:"id"`
-    Position string `json:"position" bson:"position"`
+    Name     string `json:"name" bson:"name" validate:"required,max=100,chars=name"`
+    ID       string `json:"id" bson:"id" validate:"required,max=20,chars=key"`
+    Position string `json:"position" bson:"position" validate:"required,max=100,chars=text"`
+    crud.Meta `bson:",inline"`
 }
\ No newline at end of file
@@ -14,7 +14,13 @@ const apm = initApm({
   serviceName: 'kindergarten-frontend',
   serverUrl: '',
   serviceVersion: '1.0.0',
-  environment: 'development'
+  environment: 'development',
+  // Send trace headers to the services so their transactions join the trace
+  distributedTracingOrigins: [
+    'http://192.168.56.10:30001',
+    'http://192.168.56.10:30002',
+    'http://192.168.56.10:30003'
+  ]
 })
 
 
@@ -1,7 +1,10 @@
 package models
 
+import "kindergarten-core/crud"
+
 type Student struct {
-    Name    string `json:"name" bson:"name"`
-    Roll    string `json:"roll" bson:"roll"`
-    Address string `json:"address" bson:"address"`
+    Name    string `json:"name" bson:"name" validate:"required,max=100,chars=name"`
+    Roll    string `json:"roll" bson:"roll" validate:"required,max=20,chars=key"`
+    Address string `json:"address" bson:"address" validate:"required,max=200,chars=text"`
+    crud.Meta `bson:",inline"`
 }
\ No newline at end of file